@@ -20,13 +20,20 @@ type Container struct {
 
 	Authenticate *core.Authenticate
 
-	Producer *core.MessageBrokerProducer
+	Producer core.Publisher
+	Memory   *core.MemoryBroker
+	Kafka    *core.KafkaBroker
 	SNS      *session.Session
 	SQS      *session.Session
+	S3       *session.Session
+
+	Resolver   *core.Resolver
+	ClaimCheck *core.ClaimCheckStore
 
 	InventoryRepository inventory.Repository
 	InventoryService    inventory.Service
 	InventoryController inventory.Controller
+	InventoryDispatcher *inventory.Dispatcher
 }
 
 // NewContainer creates new instace of Container
@@ -38,6 +45,133 @@ func NewContainer(settings *core.Settings) *Container {
 
 	container.DBConnPool = connectPostgres(settings.Postgres)
 
+	encoding := brokerEncoding(settings.Broker)
+
+	switch brokerType(settings.Broker) {
+	case core.BrokerMemory:
+		container.Memory = core.NewMemoryBroker(core.WithMemoryEncoding(encoding))
+		container.Producer = container.Memory
+	case core.BrokerKafka:
+		if settings.Broker.Kafka == nil {
+			logrus.Fatal("kafka settings are missing")
+		}
+
+		container.Kafka = core.NewKafkaBroker(settings.Broker.Kafka.Brokers, core.WithKafkaEncoding(encoding))
+		container.Producer = container.Kafka
+	case core.BrokerSNS:
+		container.Producer = newSNSProducer(container, settings, encoding)
+	default:
+		logrus.Fatalf("unknown message broker %s", settings.Broker.Type)
+	}
+
+	container.Authenticate = core.NewAuthenticate(settings.JWT.Secret)
+
+	container.InventoryRepository = postgres.NewRepository(container.DBConnPool)
+	var serviceOpts []inventory.ServiceOption
+	if settings.Locks != nil {
+		serviceOpts = append(serviceOpts,
+			inventory.WithDefaultLockTTL(settings.Locks.DefaultTTL),
+			inventory.WithReleaseBatchSize(settings.Locks.ReleaseBatchSize),
+		)
+	}
+
+	container.InventoryService = inventory.NewService(container.InventoryRepository, serviceOpts...)
+	container.InventoryController = inventory.NewController(settings, container.Authenticate, container.InventoryService)
+	var dispatcherOpts []inventory.DispatcherOption
+	if settings.Dispatcher != nil {
+		dispatcherOpts = append(dispatcherOpts, inventory.WithDispatchLease(settings.Dispatcher.Lease))
+	}
+
+	if container.Kafka != nil {
+		dispatcherOpts = append(dispatcherOpts, inventory.WithSnapshotPerItem())
+	}
+
+	container.InventoryDispatcher = inventory.NewDispatcher(container.InventoryRepository, container.Producer, settings.Events, dispatcherOpts...)
+
+	return container
+}
+
+// Subscriber creates a subscriber of the configured message broker
+func (c *Container) Subscriber(opts ...core.MessageBrokerSubscriberOption) core.Subscriber {
+	if c.Memory != nil {
+		return c.Memory.Subscriber(opts...)
+	}
+
+	if c.Kafka != nil {
+		return c.Kafka.Subscriber(opts...)
+	}
+
+	defaults := []core.MessageBrokerSubscriberOption{
+		core.WithSessionSNS(c.SNS),
+		core.WithSessionSQS(c.SQS),
+		core.WithSubscriberResolver(c.Resolver),
+	}
+
+	if conf := c.Settings.Subscriber; conf != nil {
+		defaults = append(defaults,
+			core.WithConcurrency(conf.Concurrency),
+			core.WithMaxMessages(conf.MaxMessages),
+			core.WithVisibilityTimeout(conf.VisibilityTimeout),
+		)
+
+		// a zero wait time would turn long polling off
+		if conf.WaitTime > 0 {
+			defaults = append(defaults, core.WithWaitTime(conf.WaitTime))
+		}
+	}
+
+	opts = append(defaults, opts...)
+
+	if c.ClaimCheck != nil {
+		opts = append(opts, core.WithSubscriberClaimCheck(c.ClaimCheck))
+	}
+
+	return core.NewMessageBrokerSubscriber(opts...)
+}
+
+// Controllers maps all routes and exposes them
+func (c *Container) Controllers() []core.Controller {
+	return []core.Controller{
+		&c.InventoryController,
+	}
+}
+
+// Close terminates every opened resource
+func (c *Container) Close() {
+	if c.Kafka != nil {
+		if err := c.Kafka.Close(); err != nil {
+			logrus.WithError(err).Error("error while closing kafka writer")
+		}
+	}
+
+	if c.DBConnPool != nil {
+		c.DBConnPool.Close()
+	}
+}
+
+func brokerType(conf *core.BrokerConfig) string {
+	if conf == nil || conf.Type == "" {
+		return core.BrokerSNS
+	}
+
+	return conf.Type
+}
+
+func brokerEncoding(conf *core.BrokerConfig) core.Encoding {
+	if conf == nil {
+		return core.EncodingJSON
+	}
+
+	encoding, err := core.ParseEncoding(conf.Encoding)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid message broker encoding")
+	}
+
+	return encoding
+}
+
+// newSNSProducer also sets up the aws sessions used by the sns subscribers
+func newSNSProducer(container *Container, settings *core.Settings, encoding core.Encoding) *core.MessageBrokerProducer {
 	container.SQS = core.NewSession(
 		settings.SQS.Region,
 		settings.SQS.Endpoint,
@@ -54,27 +188,27 @@ func NewContainer(settings *core.Settings) *Container {
 		settings.SNS.Fake,
 	)
 
-	container.Producer = core.NewMessageBrokerProducer(container.SNS)
+	container.Resolver = core.NewResolver(container.SNS, container.SQS)
 
-	container.Authenticate = core.NewAuthenticate(settings.JWT.Secret)
-
-	container.InventoryRepository = postgres.NewRepository(container.DBConnPool)
-	container.InventoryService = inventory.NewService(container.InventoryRepository)
-	container.InventoryController = inventory.NewController(settings, container.Authenticate, container.InventoryService)
-
-	return container
-}
+	producerOpts := []core.ProducerOption{
+		core.WithResolver(container.Resolver),
+		core.WithEncoding(encoding),
+	}
 
-// Controllers maps all routes and exposes them
-func (c *Container) Controllers() []core.Controller {
-	return []core.Controller{
-		&c.InventoryController,
+	if settings.S3 != nil && settings.S3.Bucket != "" {
+		container.S3 = core.NewSession(
+			settings.S3.Region,
+			settings.S3.Endpoint,
+			settings.S3.Path,
+			settings.S3.Profile,
+			settings.S3.Fake,
+		)
+
+		container.ClaimCheck = core.NewClaimCheckStore(container.S3, settings.S3.Bucket)
+		producerOpts = append(producerOpts, core.WithClaimCheck(container.ClaimCheck))
 	}
-}
 
-// Close terminates every opened resource
-func (c *Container) Close() {
-	c.DBConnPool.Close()
+	return core.NewMessageBrokerProducer(container.SNS, producerOpts...)
 }
 
 func connectPostgres(conf *core.PostgresConfig) *pgxpool.Pool {
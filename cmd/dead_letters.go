@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// DeadLetterList prints the dead letter queues and their approximate number of messages
+func DeadLetterList(command *cobra.Command, args []string) {
+	container := newDeadLetterContainer(command)
+
+	queues, err := core.ListDeadLetterQueues(container.SQS)
+	container.Close()
+
+	if err != nil {
+		logrus.WithError(err).Fatal("error while listing dead letter queues")
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "QUEUE\tMESSAGES")
+
+	for _, queue := range queues {
+		fmt.Fprintf(writer, "%s\t%d\n", queue.Name, queue.Messages)
+	}
+
+	writer.Flush()
+}
+
+// DeadLetterPeek prints the dead letters matching the filter flags
+// as json lines, leaving them in the queue
+func DeadLetterPeek(command *cobra.Command, args []string) {
+	filter := deadLetterFilter(command)
+
+	var letters []*core.DeadLetter
+
+	err := withDeadLetterQueue(command, func(queue *core.DeadLetterQueue) (err error) {
+		letters, err = queue.Peek(filter)
+		return err
+	})
+
+	if err != nil {
+		logrus.WithError(err).Fatal("error while peeking dead letters")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, letter := range letters {
+		encoder.Encode(letter)
+	}
+}
+
+// DeadLetterPurge deletes the dead letters matching the filter flags, the
+// whole queue is only purged when no filter is given along with --all
+func DeadLetterPurge(command *cobra.Command, args []string) {
+	filter := deadLetterFilter(command)
+
+	if all, _ := command.Flags().GetBool("all"); filter.Empty() && !all {
+		logrus.Fatal("no filter given, run with --all to purge the whole queue")
+	}
+
+	fields := logrus.Fields{}
+
+	err := withDeadLetterQueue(command, func(queue *core.DeadLetterQueue) error {
+		purged, err := queue.Purge(filter)
+
+		fields["queue"] = queue.Name()
+		fields["purged"] = purged
+
+		return err
+	})
+
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Fatal("error while purging dead letters")
+	}
+
+	logrus.WithFields(fields).Info("purged dead letters")
+}
+
+// DeadLetterRedrive sends the dead letters matching the filter flags
+// back to the queue of the subscriber
+func DeadLetterRedrive(command *cobra.Command, args []string) {
+	filter := deadLetterFilter(command)
+	fields := logrus.Fields{}
+
+	err := withDeadLetterQueue(command, func(queue *core.DeadLetterQueue) error {
+		redriven, err := queue.Redrive(filter)
+
+		fields["queue"] = queue.Name()
+		fields["redriven"] = redriven
+
+		return err
+	})
+
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Fatal("error while redriving dead letters")
+	}
+
+	logrus.WithFields(fields).Info("redrove dead letters")
+}
+
+func newDeadLetterContainer(command *cobra.Command) *Container {
+	settings := new(core.Settings)
+
+	err := core.FromYAML(command.Flag("settings").Value.String(), settings)
+	if err != nil {
+		logrus.
+			WithError(err).
+			Fatal("unable to parse settings, shutting down...")
+	}
+
+	if brokerType(settings.Broker) != core.BrokerSNS {
+		logrus.Fatal("dead letter queues are only available with the sns broker")
+	}
+
+	// only the aws sessions are needed, not the database
+	container := &Container{Settings: settings}
+	container.Producer = newSNSProducer(container, settings, core.EncodingJSON)
+
+	return container
+}
+
+// withDeadLetterQueue calls fn with the dead letter queue of the subscriber
+// given by the flags. The flags are checked before connecting, and the
+// container is closed before returning, so callers can exit on errors
+func withDeadLetterQueue(command *cobra.Command, fn func(queue *core.DeadLetterQueue) error) error {
+	subscriberID, _ := command.Flags().GetString("subscriber")
+	topicID, _ := command.Flags().GetString("topic")
+
+	if subscriberID == "" {
+		logrus.Fatal("the --subscriber flag is required")
+	}
+
+	container := newDeadLetterContainer(command)
+	defer container.Close()
+
+	subscriber := core.NewMessageBrokerSubscriber(
+		core.WithSessionSNS(container.SNS),
+		core.WithSessionSQS(container.SQS),
+		core.WithSubscriberResolver(container.Resolver),
+		core.WithSubscriberID(subscriberID),
+		core.WithTopicID(topicID),
+	)
+
+	queue, err := subscriber.DeadLetterQueue()
+	if err != nil {
+		return fmt.Errorf("getting dead letter queue of %s: %w", subscriberID, err)
+	}
+
+	return fn(queue)
+}
+
+func deadLetterFilter(command *cobra.Command) *core.DeadLetterFilter {
+	filter := new(core.DeadLetterFilter)
+
+	filter.OlderThan, _ = command.Flags().GetDuration("older-than")
+	filter.NewerThan, _ = command.Flags().GetDuration("newer-than")
+	filter.Contains, _ = command.Flags().GetString("contains")
+	filter.Limit, _ = command.Flags().GetInt("limit")
+
+	return filter
+}
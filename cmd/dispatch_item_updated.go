@@ -2,14 +2,24 @@ package cmd
 
 import (
 	"context"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/d-leme/tradew-inventory-write/pkg/core"
-	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-// DispatchItemUpdated ...
+const (
+	defaultDispatchInterval  = 5 * time.Second
+	defaultDispatchBatchSize = 500
+)
+
+// DispatchItemUpdated publishes the pending outbox events as soon as postgres
+// notifies new ones, sweeping the outbox every interval to catch missed
+// notifications, until SIGINT or SIGTERM is received. The batch being
+// published when the signal arrives is always finished
 func DispatchItemUpdated(command *cobra.Command, args []string) {
 	settings := new(core.Settings)
 
@@ -20,45 +30,73 @@ func DispatchItemUpdated(command *cobra.Command, args []string) {
 			Fatal("unable to parse settings, shutting down...")
 	}
 
-	ctx := context.Background()
+	once, _ := command.Flags().GetBool("once")
+	interval, batchSize := dispatcherConfig(settings.Dispatcher)
+
 	container := NewContainer(settings)
+	defer container.Close()
 
-	items, err := container.InventoryRepository.GetByStatus(ctx, inventory.ItemPendingUpdateDispatch)
-	if err != nil {
-		logrus.WithError(err).Error("error while getting items by status")
-		return
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	lenItems := len(items)
+	fields := logrus.Fields{"interval": interval, "batch_size": batchSize}
+	logrus.WithFields(fields).Info("starting dispatch-item-updated-worker")
 
-	logrus.Infof("%d new items to publish", lenItems)
+	var notifications <-chan struct{}
 
-	if lenItems < 1 {
-		return
+	if !once {
+		notifications, err = container.InventoryRepository.ListenOutbox(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("unable to listen outbox notifications, polling only")
+		}
 	}
 
-	event := inventory.ParseItemsToItemsUpdatedEvent(items)
-	messageID, err := container.Producer.Publish(settings.Events.ItemsUpdated, event)
-
-	if err != nil {
-		logrus.WithError(err).Error("error while dispatching message")
-		return
+	for {
+		// the batch does not use ctx, so a signal never interrupts it halfway
+		sent, err := container.InventoryDispatcher.Dispatch(context.Background(), batchSize)
+		if err != nil {
+			logrus.WithError(err).WithField("sent", sent).Error("error while dispatching events")
+		}
+
+		if once {
+			logrus.WithField("sent", sent).Info("worker complete")
+			return
+		}
+
+		// a full batch means there are more events waiting
+		wait := interval
+		if err == nil && sent == batchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			logrus.Info("dispatch-item-updated-worker stopped")
+			return
+		case _, ok := <-notifications:
+			if !ok && ctx.Err() == nil {
+				logrus.Error("stopped listening outbox notifications, polling only")
+			}
+
+			if !ok {
+				notifications = nil
+			}
+		case <-time.After(wait):
+		}
 	}
+}
 
-	fields := logrus.Fields{"message_id": messageID}
-
-	logrus.WithFields(fields).Info("dipached event")
+func dispatcherConfig(conf *core.DispatcherConfig) (time.Duration, int) {
+	interval := defaultDispatchInterval
+	batchSize := defaultDispatchBatchSize
 
-	for _, item := range items {
-		item.UpdateStatus(inventory.ItemAvailable)
+	if conf != nil && conf.Interval > 0 {
+		interval = conf.Interval
 	}
 
-	if err := container.InventoryRepository.UpdateBulk(ctx, items); err != nil {
-		logrus.WithError(err).WithFields(fields).Error("error while updating items")
-		return
+	if conf != nil && conf.BatchSize > 0 {
+		batchSize = conf.BatchSize
 	}
 
-	logrus.
-		WithFields(fields).
-		Info("worker complete")
+	return interval, batchSize
 }
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// ReleaseExpiredLocks releases every item lock which ttl has expired, a batch
+// of items at a time. The released items are published by the outbox dispatcher
+func ReleaseExpiredLocks(command *cobra.Command, args []string) {
+	settings := new(core.Settings)
+
+	err := core.FromYAML(command.Flag("settings").Value.String(), settings)
+	if err != nil {
+		logrus.
+			WithError(err).
+			Fatal("unable to parse settings, shutting down...")
+	}
+
+	container := NewContainer(settings)
+	defer container.Close()
+
+	if err := container.InventoryService.ReleaseExpiredLocks(context.Background()); err != nil {
+		logrus.WithError(err).Error("error while releasing expired locks")
+		return
+	}
+
+	logrus.Info("worker complete")
+}
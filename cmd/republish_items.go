@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// RepublishItems publishes the current state of the items to the items-updated
+// topic again, so the read side can be rebuilt, without changing the items
+func RepublishItems(command *cobra.Command, args []string) {
+	settings := new(core.Settings)
+
+	err := core.FromYAML(command.Flag("settings").Value.String(), settings)
+	if err != nil {
+		logrus.
+			WithError(err).
+			Fatal("unable to parse settings, shutting down...")
+	}
+
+	filter, err := republishFilter(command)
+	if err != nil {
+		logrus.
+			WithError(err).
+			Fatal("invalid filter, shutting down...")
+	}
+
+	_, batchSize := dispatcherConfig(settings.Dispatcher)
+	if size, _ := command.Flags().GetInt("batch-size"); size > 0 {
+		batchSize = size
+	}
+
+	container := NewContainer(settings)
+	defer container.Close()
+
+	fields := logrus.Fields{
+		"owner_id":      filter.OwnerID,
+		"ids":           filter.IDs,
+		"updated_since": filter.UpdatedSince,
+		"batch_size":    batchSize,
+	}
+
+	published, err := container.InventoryDispatcher.Republish(context.Background(), filter, batchSize)
+
+	fields["published"] = published
+
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while republishing items")
+		return
+	}
+
+	logrus.WithFields(fields).Info("worker complete")
+}
+
+func republishFilter(command *cobra.Command) (*inventory.ItemFilter, error) {
+	filter := new(inventory.ItemFilter)
+
+	filter.OwnerID, _ = command.Flags().GetString("owner")
+	filter.IDs, _ = command.Flags().GetStringSlice("ids")
+
+	updatedSince, _ := command.Flags().GetString("updated-since")
+	if updatedSince != "" {
+		since, err := time.Parse(time.RFC3339, updatedSince)
+		if err != nil {
+			return nil, err
+		}
+
+		filter.UpdatedSince = &since
+	}
+
+	return filter, nil
+}
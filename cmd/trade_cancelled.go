@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	tradeCancelledSubscriberID = "inventory-write-trade-cancelled"
+	tradeRejectedSubscriberID  = "inventory-write-trade-rejected"
+)
+
+// TradeCancelled releases the locks of the trades cancelled or rejected
+// by the trade service, as their messages arrive, until SIGINT or SIGTERM
+// is received
+func TradeCancelled(command *cobra.Command, args []string) {
+	settings := new(core.Settings)
+
+	err := core.FromYAML(command.Flag("settings").Value.String(), settings)
+	if err != nil {
+		logrus.
+			WithError(err).
+			Fatal("unable to parse settings, shutting down...")
+	}
+
+	container := NewContainer(settings)
+	defer container.Close()
+
+	topics := map[string]inventory.ItemUnlockReason{}
+	subscriberIDs := map[string]string{}
+
+	if settings.Events.TradeCancelled != "" {
+		topics[settings.Events.TradeCancelled] = inventory.ItemUnlockCancelled
+		subscriberIDs[settings.Events.TradeCancelled] = tradeCancelledSubscriberID
+	}
+
+	if settings.Events.TradeRejected != "" {
+		topics[settings.Events.TradeRejected] = inventory.ItemUnlockRejected
+		subscriberIDs[settings.Events.TradeRejected] = tradeRejectedSubscriberID
+	}
+
+	if len(topics) == 0 {
+		logrus.Fatal("events.trade-cancelled and events.trade-rejected are not set, nothing to subscribe to")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// a failing subscriber stops the others, stopping the worker
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	for topic, reason := range topics {
+		subscriber := container.Subscriber(
+			core.WithSubscriberID(subscriberIDs[topic]),
+			core.WithTopicID(topic),
+			core.WithType(reflect.TypeOf(inventory.TradeCancelledEvent{})),
+			core.WithMessageHandler(inventory.NewTradeCancelledHandler(container.InventoryService, reason)),
+		)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := subscriber.Run(ctx); err != nil {
+				logrus.WithError(err).Error("trade-cancelled-worker subscriber failed")
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	logrus.Info("trade-cancelled-worker stopped")
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const userDeletedSubscriberID = "inventory-write-user-deleted"
+
+// UserDeleted removes the items of the users deleted by the user service,
+// as their messages arrive, until SIGINT or SIGTERM is received
+func UserDeleted(command *cobra.Command, args []string) {
+	settings := new(core.Settings)
+
+	err := core.FromYAML(command.Flag("settings").Value.String(), settings)
+	if err != nil {
+		logrus.
+			WithError(err).
+			Fatal("unable to parse settings, shutting down...")
+	}
+
+	if settings.Events.UserDeleted == "" {
+		logrus.Fatal("events.user-deleted is not set, nothing to subscribe to")
+	}
+
+	policy := deletionPolicy(settings)
+
+	container := NewContainer(settings)
+	defer container.Close()
+
+	subscriber := container.Subscriber(
+		core.WithSubscriberID(userDeletedSubscriberID),
+		core.WithTopicID(settings.Events.UserDeleted),
+		core.WithType(reflect.TypeOf(inventory.UserDeletedEvent{})),
+		core.WithMessageHandler(inventory.NewUserDeletedHandler(container.InventoryService, policy)),
+	)
+
+	logrus.WithField("policy", policy).Info("user-deleted-worker started")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := subscriber.Run(ctx); err != nil {
+		logrus.WithError(err).Error("user-deleted-worker failed")
+		return
+	}
+
+	logrus.Info("user-deleted-worker stopped")
+}
+
+func deletionPolicy(settings *core.Settings) inventory.DeletionPolicy {
+	var value string
+	if settings.Users != nil {
+		value = settings.Users.DeletionPolicy
+	}
+
+	policy, err := inventory.ParseDeletionPolicy(value)
+	if err != nil {
+		logrus.
+			WithError(err).
+			WithField("deletion_policy", value).
+			Fatal("invalid users.deletion-policy, shutting down...")
+	}
+
+	return policy
+}
@@ -33,8 +33,81 @@ func main() {
 		Run:   cmd.DispatchItemUpdated,
 	}
 
+	itemUpdatedWorker.Flags().Bool("once", false, "dispatches a single batch and exits")
+
+	releaseExpiredLocksWorker := &cobra.Command{
+		Use:   "release-expired-locks-worker",
+		Short: "Starts release-expired-locks-worker",
+		Run:   cmd.ReleaseExpiredLocks,
+	}
+
+	tradeCancelledWorker := &cobra.Command{
+		Use:   "trade-cancelled-worker",
+		Short: "Starts trade-cancelled-worker",
+		Run:   cmd.TradeCancelled,
+	}
+
+	userDeletedWorker := &cobra.Command{
+		Use:   "user-deleted-worker",
+		Short: "Starts user-deleted-worker",
+		Run:   cmd.UserDeleted,
+	}
+
+	republishItems := &cobra.Command{
+		Use:   "republish-items",
+		Short: "Publishes the current state of the items again",
+		Run:   cmd.RepublishItems,
+	}
+
+	republishItems.Flags().String("owner", "", "only republishes the items of this owner")
+	republishItems.Flags().StringSlice("ids", nil, "only republishes the items with these ids")
+	republishItems.Flags().String("updated-since", "", "only republishes the items updated since this RFC3339 time")
+	republishItems.Flags().Int("batch-size", 0, "how many items are read at a time, dispatcher.batch-size by default")
+
+	dlq := &cobra.Command{
+		Use:   "dlq",
+		Short: "Inspects and redrives the dead letter queues of the subscribers",
+	}
+
+	dlqList := &cobra.Command{
+		Use:   "list",
+		Short: "Lists the dead letter queues and their number of messages",
+		Run:   cmd.DeadLetterList,
+	}
+
+	dlqPeek := &cobra.Command{
+		Use:   "peek",
+		Short: "Prints the dead letters, leaving them in the queue",
+		Run:   cmd.DeadLetterPeek,
+	}
+
+	dlqPurge := &cobra.Command{
+		Use:   "purge",
+		Short: "Deletes the dead letters",
+		Run:   cmd.DeadLetterPurge,
+	}
+
+	dlqPurge.Flags().Bool("all", false, "purges the whole queue when no filter is given")
+
+	dlqRedrive := &cobra.Command{
+		Use:   "redrive",
+		Short: "Sends the dead letters back to the queue of the subscriber",
+		Run:   cmd.DeadLetterRedrive,
+	}
+
+	for _, command := range []*cobra.Command{dlqPeek, dlqPurge, dlqRedrive} {
+		command.Flags().String("subscriber", "", "id of the subscriber owning the dead letter queue")
+		command.Flags().String("topic", "", "topic of the subscriber, required for fifo topics")
+		command.Flags().Duration("older-than", 0, "only selects messages sent longer ago than this")
+		command.Flags().Duration("newer-than", 0, "only selects messages sent within this")
+		command.Flags().String("contains", "", "only selects messages whose body contains this text")
+		command.Flags().Int("limit", 0, "selects up to this number of messages")
+	}
+
+	dlq.AddCommand(dlqList, dlqPeek, dlqPurge, dlqRedrive)
+
 	root.PersistentFlags().String("settings", "./settings.yml", "path to settings.yaml config file")
-	root.AddCommand(api, grpc, itemUpdatedWorker)
+	root.AddCommand(api, grpc, itemUpdatedWorker, releaseExpiredLocksWorker, tradeCancelledWorker, userDeletedWorker, republishItems, dlq)
 
 	root.Execute()
 }
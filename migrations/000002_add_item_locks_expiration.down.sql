@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS item_locks_expires_at_idx;
+
+ALTER TABLE item_locks
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS locked_at;
@@ -0,0 +1,5 @@
+ALTER TABLE item_locks
+    ADD COLUMN IF NOT EXISTS locked_at timestamp with time zone NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS item_locks_expires_at_idx ON item_locks (expires_at);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS items_locked_quantity_check ON items;
+DROP TRIGGER IF EXISTS item_locks_quantity_check ON item_locks;
+DROP FUNCTION IF EXISTS check_item_locks_quantity();
@@ -0,0 +1,36 @@
+-- guarantees the sum of an item locks never exceeds its total quantity,
+-- the item row is locked so concurrent transactions are checked one at a time
+CREATE OR REPLACE FUNCTION check_item_locks_quantity() RETURNS trigger AS $$
+DECLARE
+    target_id text;
+    total INT;
+    locked INT;
+BEGIN
+    IF TG_TABLE_NAME = 'items' THEN
+        target_id := NEW.id;
+    ELSE
+        target_id := NEW.item_id;
+    END IF;
+
+    SELECT total_quantity INTO total FROM items WHERE id = target_id FOR UPDATE;
+
+    SELECT coalesce(sum(quantity), 0) INTO locked FROM item_locks WHERE item_id = target_id;
+
+    IF locked > total THEN
+        RAISE EXCEPTION 'locked quantity % exceeds total quantity % of item %', locked, total, target_id
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'item_locks_quantity_check';
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER item_locks_quantity_check
+    AFTER INSERT OR UPDATE ON item_locks
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE check_item_locks_quantity();
+
+CREATE CONSTRAINT TRIGGER items_locked_quantity_check
+    AFTER UPDATE OF total_quantity ON items
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE check_item_locks_quantity();
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS outbox(
+    id BIGSERIAL NOT NULL,
+    aggregate_id text NOT NULL,
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    sent_at timestamp with time zone,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
+
+-- items waiting to be dispatched by the old status flag are moved to the outbox
+INSERT INTO outbox (aggregate_id, event_type, payload, created_at)
+SELECT
+    i.id,
+    'ItemUpdated',
+    json_build_object(
+        'id', i.id,
+        'owner_id', i.owner_id,
+        'name', i.name,
+        'description', nullif(i.description, ''),
+        'total_quantity', i.total_quantity,
+        'locked_quantity', coalesce((SELECT sum(l.quantity) FROM item_locks l WHERE l.item_id = i.id), 0),
+        'created_at', i.created_at,
+        'updated_at', i.updated_at
+    ),
+    now()
+FROM items i
+WHERE i.status = 'PendingUpdateDispatch'
+ORDER BY i.updated_at;
+
+UPDATE items SET status = 'Available' WHERE status = 'PendingUpdateDispatch';
@@ -0,0 +1,2 @@
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_by text;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until timestamp with time zone;
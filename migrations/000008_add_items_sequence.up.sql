@@ -0,0 +1,4 @@
+ALTER TABLE items ADD COLUMN IF NOT EXISTS sequence bigint NOT NULL DEFAULT 0;
+
+-- existing items continue from their version, as their events were not numbered
+UPDATE items SET sequence = version WHERE sequence = 0;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS items_owner_id_idx;
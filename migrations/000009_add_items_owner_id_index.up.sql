@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS items_owner_id_idx ON items (owner_id);
@@ -0,0 +1,37 @@
+package core
+
+import "context"
+
+const (
+	// BrokerSNS publishes to sns and consumes from sqs, the default
+	BrokerSNS = "sns"
+
+	// BrokerMemory keeps messages in process, for local development and tests
+	BrokerMemory = "memory"
+
+	// BrokerKafka publishes to and consumes from kafka
+	BrokerKafka = "kafka"
+
+	// MessageKeyAttribute message attribute holding the key messages are
+	// partitioned by, messages with the same key keep their order
+	MessageKeyAttribute = "message_key"
+)
+
+// Publisher publishes messages to topics
+type Publisher interface {
+	Publish(topicID string, data interface{}) (string, error)
+	PublishWihAttribrutes(topicID string, data interface{}, attributes map[string]string) (string, error)
+	// MaxMessageSize returns the size limit of published messages, in bytes
+	MaxMessageSize() int
+	// MessageSize returns the size of data published with attributes as
+	// counted against MaxMessageSize, encoded as the publisher encodes it
+	MessageSize(data interface{}, attributes map[string]string) (int, error)
+}
+
+// Subscriber consumes the messages of a topic, configured
+// by the MessageBrokerSubscriberOption it was created with
+type Subscriber interface {
+	// Run consumes messages until ctx is done, finishing the message being
+	// handled, and returns nil once stopped by ctx
+	Run(ctx context.Context) error
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+)
+
+// ClaimCheck points to a message body stored in a bucket, it is published
+// in place of bodies bigger than the message broker size limit
+type ClaimCheck struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Size   int    `json:"size"`
+}
+
+type claimCheckMessage struct {
+	ClaimCheck *ClaimCheck `json:"claim_check"`
+}
+
+// S3Client stores and fetches objects, implemented by s3.S3
+type S3Client interface {
+	PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error)
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+// ClaimCheckStore keeps oversized message bodies in an S3 compatible bucket.
+// Stored bodies are never removed, the bucket should have a lifecycle rule
+// expiring them once every subscriber had the chance to read them
+type ClaimCheckStore struct {
+	client S3Client
+	bucket string
+}
+
+// ClaimCheckOption ...
+type ClaimCheckOption func(*ClaimCheckStore)
+
+// NewClaimCheckStore ...
+func NewClaimCheckStore(s *session.Session, bucket string, opts ...ClaimCheckOption) *ClaimCheckStore {
+	store := &ClaimCheckStore{bucket: bucket}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	if store.client == nil {
+		store.client = s3.New(s)
+	}
+
+	return store
+}
+
+// WithS3Client stores the bodies with client instead of
+// a client created from the session
+func WithS3Client(client S3Client) ClaimCheckOption {
+	return func(c *ClaimCheckStore) {
+		c.client = client
+	}
+}
+
+// Put stores body, encoded as contentType, under the topic prefix
+// and returns the message to publish instead
+func (c *ClaimCheckStore) Put(topicID string, body []byte, contentType string) ([]byte, error) {
+	key := fmt.Sprintf("%s/%s", topicID, uuid.NewString())
+
+	_, err := c.client.PutObjectWithContext(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&claimCheckMessage{
+		ClaimCheck: &ClaimCheck{Bucket: c.bucket, Key: key, Size: len(body)},
+	})
+}
+
+// Resolve returns the stored body when message is a claim check,
+// any other message is returned as it is
+func (c *ClaimCheckStore) Resolve(message []byte) ([]byte, error) {
+	check := new(claimCheckMessage)
+
+	if err := json.Unmarshal(message, check); err != nil || check.ClaimCheck == nil {
+		return message, nil
+	}
+
+	output, err := c.client.GetObjectWithContext(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(check.ClaimCheck.Bucket),
+		Key:    aws.String(check.ClaimCheck.Key),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer output.Body.Close()
+
+	return ioutil.ReadAll(output.Body)
+}
@@ -0,0 +1,147 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type claimCheckTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	s3     *standInS3
+	store  *core.ClaimCheckStore
+}
+
+func TestClaimCheckTestSuite(t *testing.T) {
+	suite.Run(t, new(claimCheckTestSuite))
+}
+
+func (s *claimCheckTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *claimCheckTestSuite) SetupTest() {
+	s.s3 = newStandInS3()
+	s.store = core.NewClaimCheckStore(nil, "inventory-claims", core.WithS3Client(s.s3))
+}
+
+func (s *claimCheckTestSuite) TestPut() {
+	body := []byte(`{"id":"1"}`)
+
+	message, err := s.store.Put("items-updated", body, core.ContentTypeProtoJSON)
+	s.assert.NoError(err)
+
+	check := new(struct {
+		ClaimCheck *core.ClaimCheck `json:"claim_check"`
+	})
+	s.assert.NoError(json.Unmarshal(message, check))
+
+	s.assert.Equal("inventory-claims", check.ClaimCheck.Bucket)
+	s.assert.True(strings.HasPrefix(check.ClaimCheck.Key, "items-updated/"))
+	s.assert.Equal(len(body), check.ClaimCheck.Size)
+
+	object := s.s3.objects["inventory-claims/"+check.ClaimCheck.Key]
+	s.assert.Equal(body, object.body)
+	s.assert.Equal(core.ContentTypeProtoJSON, object.contentType)
+}
+
+func (s *claimCheckTestSuite) TestPutFailed() {
+	s.s3.putErr = errors.New("put failed")
+
+	message, err := s.store.Put("items-updated", []byte(`{"id":"1"}`), core.ContentTypeJSON)
+
+	s.assert.Equal(s.s3.putErr, err)
+	s.assert.Nil(message)
+}
+
+func (s *claimCheckTestSuite) TestResolve() {
+	body := []byte(`{"id":"1"}`)
+
+	message, err := s.store.Put("items-updated", body, core.ContentTypeJSON)
+	s.assert.NoError(err)
+
+	resolved, err := s.store.Resolve(message)
+
+	s.assert.NoError(err)
+	s.assert.Equal(body, resolved)
+}
+
+func (s *claimCheckTestSuite) TestResolveMessage() {
+	message := []byte(`{"id":"1"}`)
+
+	resolved, err := s.store.Resolve(message)
+
+	s.assert.NoError(err)
+	s.assert.Equal(message, resolved)
+	s.assert.Empty(s.s3.objects)
+}
+
+func (s *claimCheckTestSuite) TestResolveMissing() {
+	_, err := s.store.Resolve([]byte(`{"claim_check":{"bucket":"inventory-claims","key":"items-updated/1","size":10}}`))
+
+	s.assert.Error(err)
+}
+
+type standInObject struct {
+	body        []byte
+	contentType string
+}
+
+// standInS3 keeps the objects of every bucket in memory, by bucket and key
+type standInS3 struct {
+	mu      sync.Mutex
+	objects map[string]*standInObject
+	putErr  error
+}
+
+func newStandInS3() *standInS3 {
+	return &standInS3{objects: map[string]*standInObject{}}
+}
+
+func (c *standInS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.putErr != nil {
+		return nil, c.putErr
+	}
+
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = &standInObject{
+		body:        body,
+		contentType: aws.StringValue(input.ContentType),
+	}
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (c *standInS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	object, ok := c.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, errors.New(s3.ErrCodeNoSuchKey)
+	}
+
+	return &s3.GetObjectOutput{
+		Body:        ioutil.NopCloser(bytes.NewReader(object.body)),
+		ContentType: aws.String(object.contentType),
+	}, nil
+}
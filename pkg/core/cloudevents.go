@@ -0,0 +1,55 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// CloudEventsSpecVersion version of the cloud events specification
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsContentType content type of cloud events in structured mode
+	CloudEventsContentType = "application/cloudevents+json"
+)
+
+// CloudEvent is a cloud events structured mode envelope,
+// see https://github.com/cloudevents/spec/blob/v1.0/spec.md
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	// DataBase64 replaces Data when the data is binary
+	DataBase64 string `json:"data_base64,omitempty"`
+	// Payload is the typed data, publishers encoding protobuf replace Data by
+	// its protobuf representation when it implements ProtoMarshaler
+	Payload interface{} `json:"-"`
+}
+
+// UnwrapCloudEvent returns the data of body and its envelope when body is a
+// cloud event, any other body is a legacy message returned as it is
+func UnwrapCloudEvent(body []byte) ([]byte, *CloudEvent) {
+	event := new(CloudEvent)
+
+	if err := json.Unmarshal(body, event); err != nil || event.SpecVersion == "" {
+		return body, nil
+	}
+
+	if event.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(event.DataBase64)
+		if err != nil {
+			return body, nil
+		}
+
+		return data, event
+	}
+
+	return event.Data, event
+}
@@ -0,0 +1,506 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dlqSuffix = "_dlq"
+
+	// deadLetterVisibility hides scanned messages from other receivers until
+	// the scan ends, it must be longer than a whole scan takes
+	deadLetterVisibility = 5 * 60
+
+	// sqs returns and accepts up to 10 messages per batch
+	sqsBatchSize = 10
+
+	// sqsMaxBatchSize is the size limit of the messages of a batch together
+	sqsMaxBatchSize = 256 * 1024
+)
+
+// DeadLetter is a message of a dead letter queue
+type DeadLetter struct {
+	ID string `json:"id"`
+	// Body is the message published to the topic, unwrapped
+	// from the notification delivered by sns
+	Body         string            `json:"body"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	SentAt       time.Time         `json:"sent_at"`
+	ReceiveCount int               `json:"receive_count"`
+
+	message *sqs.Message
+}
+
+// DeadLetterFilter selects dead letters by age and content,
+// the fields left empty match every message
+type DeadLetterFilter struct {
+	OlderThan time.Duration
+	NewerThan time.Duration
+	// Contains is matched against the body of the messages
+	Contains string
+	// Limit stops scanning once as many messages have matched
+	Limit int
+}
+
+// Empty reports whether the filter matches every message
+func (f *DeadLetterFilter) Empty() bool {
+	return f.OlderThan == 0 && f.NewerThan == 0 && f.Contains == "" && f.Limit == 0
+}
+
+// Match reports whether the dead letter is selected by the filter at the time given
+func (f *DeadLetterFilter) Match(letter *DeadLetter, now time.Time) bool {
+	age := now.Sub(letter.SentAt)
+
+	if f.OlderThan > 0 && age < f.OlderThan {
+		return false
+	}
+
+	if f.NewerThan > 0 && age > f.NewerThan {
+		return false
+	}
+
+	return f.Contains == "" || strings.Contains(letter.Body, f.Contains)
+}
+
+// DeadLetterQueueStats ...
+type DeadLetterQueueStats struct {
+	Name     string
+	Messages int
+}
+
+// ListDeadLetterQueues returns the dead letter queues created by subscribers
+// along with their approximate number of messages
+func ListDeadLetterQueues(sessionSQS *session.Session) ([]*DeadLetterQueueStats, error) {
+	sqsSvc := sqs.New(sessionSQS)
+
+	var urls []string
+
+	err := sqsSvc.ListQueuesPages(&sqs.ListQueuesInput{}, func(page *sqs.ListQueuesOutput, lastPage bool) bool {
+		for _, url := range page.QueueUrls {
+			name := (*url)[strings.LastIndex(*url, "/")+1:]
+
+			if strings.HasSuffix(strings.TrimSuffix(name, fifoSuffix), dlqSuffix) {
+				urls = append(urls, *url)
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*DeadLetterQueueStats, len(urls))
+
+	for i, url := range urls {
+		messages, err := approximateMessages(sqsSvc, url)
+		if err != nil {
+			return nil, err
+		}
+
+		stats[i] = &DeadLetterQueueStats{
+			Name:     url[strings.LastIndex(url, "/")+1:],
+			Messages: messages,
+		}
+	}
+
+	return stats, nil
+}
+
+// DeadLetterQueue inspects the dead letter queue of a subscriber and
+// redrives its messages back to the queue of the subscriber
+type DeadLetterQueue struct {
+	client   SQSClient
+	name     string
+	url      string
+	queueURL string
+}
+
+// NewDeadLetterQueue returns the dead letter queue at url, redriving
+// its messages to the queue at queueURL
+func NewDeadLetterQueue(client SQSClient, url, queueURL string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		client:   client,
+		name:     url[strings.LastIndex(url, "/")+1:],
+		url:      url,
+		queueURL: queueURL,
+	}
+}
+
+// DeadLetterQueue returns the dead letter queue created by Run, or ErrNotFound
+// when the subscriber never ran. The subscriber must be configured with its id
+// and topic, as well as the aws sessions or a resolver
+func (s *MessageBrokerSubscriber) DeadLetterQueue() (*DeadLetterQueue, error) {
+	queueName, dlqName := s.queueNames()
+
+	queueURL, found, err := s.resolver.QueueURL(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	dlqURL, dlqFound, err := s.resolver.QueueURL(dlqName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found || !dlqFound {
+		return nil, ErrNotFound
+	}
+
+	return NewDeadLetterQueue(s.client, dlqURL, queueURL), nil
+}
+
+// Name ...
+func (q *DeadLetterQueue) Name() string {
+	return q.name
+}
+
+// Count returns the approximate number of messages in the queue
+func (q *DeadLetterQueue) Count() (int, error) {
+	return approximateMessages(q.client, q.url)
+}
+
+// Peek returns the messages matching filter, leaving them in the queue
+func (q *DeadLetterQueue) Peek(filter *DeadLetterFilter) ([]*DeadLetter, error) {
+	var peeked []*DeadLetter
+
+	err := q.scan(filter, func(letters []*DeadLetter) ([]*DeadLetter, error) {
+		peeked = append(peeked, letters...)
+		return nil, nil
+	})
+
+	return peeked, err
+}
+
+// Purge deletes the messages matching filter and returns how many were
+// deleted, an empty filter purges the whole queue at once
+func (q *DeadLetterQueue) Purge(filter *DeadLetterFilter) (int, error) {
+	if filter.Empty() {
+		count, err := q.Count()
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = q.client.PurgeQueueWithContext(context.Background(), &sqs.PurgeQueueInput{QueueUrl: aws.String(q.url)})
+		if err != nil {
+			return 0, err
+		}
+
+		return count, nil
+	}
+
+	var purged int
+
+	err := q.scan(filter, func(letters []*DeadLetter) ([]*DeadLetter, error) {
+		deleted, err := q.delete(letters)
+		purged = purged + len(deleted)
+
+		return deleted, err
+	})
+
+	return purged, err
+}
+
+// Redrive sends the messages matching filter back to the queue of the
+// subscriber, deleting them from the dead letter queue once sent, and
+// returns how many were redriven
+func (q *DeadLetterQueue) Redrive(filter *DeadLetterFilter) (int, error) {
+	var redriven int
+
+	err := q.scan(filter, func(letters []*DeadLetter) ([]*DeadLetter, error) {
+		// the messages sent before a failure are still deleted,
+		// so they are not sent again by the next redrive
+		sent, sendErr := q.send(letters)
+
+		deleted, err := q.delete(sent)
+		redriven = redriven + len(deleted)
+
+		if sendErr != nil {
+			return deleted, sendErr
+		}
+
+		return deleted, err
+	})
+
+	return redriven, err
+}
+
+// scan receives every message of the queue and calls fn with the ones
+// matching filter, a batch at a time. Received messages stay hidden until the
+// scan ends, so none is received twice, then the ones fn did not return as
+// removed are made visible again
+func (q *DeadLetterQueue) scan(filter *DeadLetterFilter, fn func([]*DeadLetter) ([]*DeadLetter, error)) error {
+	var received []*DeadLetter
+	removed := map[string]bool{}
+	matched := 0
+
+	defer func() {
+		q.restore(received, removed)
+	}()
+
+	for filter.Limit == 0 || matched < filter.Limit {
+		output, err := q.client.ReceiveMessageWithContext(context.Background(), &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(q.url),
+			MaxNumberOfMessages:   aws.Int64(sqsBatchSize),
+			VisibilityTimeout:     aws.Int64(deadLetterVisibility),
+			WaitTimeSeconds:       aws.Int64(1),
+			AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+			MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if len(output.Messages) == 0 {
+			return nil
+		}
+
+		var batch []*DeadLetter
+		now := time.Now()
+
+		for _, message := range output.Messages {
+			letter := newDeadLetter(message)
+			received = append(received, letter)
+
+			if (filter.Limit == 0 || matched < filter.Limit) && filter.Match(letter, now) {
+				batch = append(batch, letter)
+				matched++
+			}
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		done, err := fn(batch)
+		for _, letter := range done {
+			removed[letter.ID] = true
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restore makes the received messages left in the queue visible again
+func (q *DeadLetterQueue) restore(received []*DeadLetter, removed map[string]bool) {
+	var entries []*sqs.ChangeMessageVisibilityBatchRequestEntry
+
+	for _, letter := range received {
+		if removed[letter.ID] {
+			continue
+		}
+
+		entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(len(entries))),
+			ReceiptHandle:     letter.message.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(0),
+		})
+
+		if len(entries) == sqsBatchSize {
+			q.changeVisibility(entries)
+			entries = nil
+		}
+	}
+
+	if len(entries) > 0 {
+		q.changeVisibility(entries)
+	}
+}
+
+func (q *DeadLetterQueue) changeVisibility(entries []*sqs.ChangeMessageVisibilityBatchRequestEntry) {
+	output, err := q.client.ChangeMessageVisibilityBatchWithContext(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(q.url),
+		Entries:  entries,
+	})
+
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("error restoring visibility of %d messages of %s, visible again in %d seconds", len(entries), q.name, deadLetterVisibility)
+		return
+	}
+
+	if len(output.Failed) > 0 {
+		logrus.Errorf("error restoring visibility of %d messages of %s, visible again in %d seconds", len(output.Failed), q.name, deadLetterVisibility)
+	}
+}
+
+// send returns the messages sent to the queue of the subscriber, in as
+// many batches as needed to keep each one within the sqs size limit
+func (q *DeadLetterQueue) send(letters []*DeadLetter) ([]*DeadLetter, error) {
+	var sent []*DeadLetter
+	var start, size int
+
+	for end, letter := range letters {
+		if end > start && size+letter.size() > sqsMaxBatchSize {
+			batch, err := q.sendBatch(letters[start:end])
+			sent = append(sent, batch...)
+
+			if err != nil {
+				return sent, err
+			}
+
+			start, size = end, 0
+		}
+
+		size = size + letter.size()
+	}
+
+	if start == len(letters) {
+		return sent, nil
+	}
+
+	batch, err := q.sendBatch(letters[start:])
+
+	return append(sent, batch...), err
+}
+
+func (q *DeadLetterQueue) sendBatch(letters []*DeadLetter) ([]*DeadLetter, error) {
+	entries := make([]*sqs.SendMessageBatchRequestEntry, len(letters))
+
+	for i, letter := range letters {
+		entries[i] = &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			MessageBody:       letter.message.Body,
+			MessageAttributes: letter.message.MessageAttributes,
+		}
+
+		// fifo queues require a group, the id of the message
+		// keeps the redrive from sending it twice
+		if groupID := letter.message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; groupID != nil {
+			entries[i].MessageGroupId = groupID
+			entries[i].MessageDeduplicationId = letter.message.MessageId
+		}
+	}
+
+	output, err := q.client.SendMessageBatchWithContext(context.Background(), &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(q.queueURL),
+		Entries:  entries,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sent := make([]*DeadLetter, len(output.Successful))
+	for i, entry := range output.Successful {
+		index, _ := strconv.Atoi(*entry.Id)
+		sent[i] = letters[index]
+	}
+
+	if len(output.Failed) > 0 {
+		return sent, fmt.Errorf("%d messages could not be sent: %s", len(output.Failed), aws.StringValue(output.Failed[0].Message))
+	}
+
+	return sent, nil
+}
+
+// delete returns the messages deleted from the dead letter queue
+func (q *DeadLetterQueue) delete(letters []*DeadLetter) ([]*DeadLetter, error) {
+	if len(letters) == 0 {
+		return nil, nil
+	}
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(letters))
+
+	for i, letter := range letters {
+		entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: letter.message.ReceiptHandle,
+		}
+	}
+
+	output, err := q.client.DeleteMessageBatchWithContext(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(q.url),
+		Entries:  entries,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make([]*DeadLetter, len(output.Successful))
+	for i, entry := range output.Successful {
+		index, _ := strconv.Atoi(*entry.Id)
+		deleted[i] = letters[index]
+	}
+
+	if len(output.Failed) > 0 {
+		return deleted, fmt.Errorf("%d messages could not be deleted: %s", len(output.Failed), aws.StringValue(output.Failed[0].Message))
+	}
+
+	return deleted, nil
+}
+
+func newDeadLetter(message *sqs.Message) *DeadLetter {
+	letter := &DeadLetter{
+		ID:         aws.StringValue(message.MessageId),
+		Body:       aws.StringValue(message.Body),
+		Attributes: map[string]string{},
+		message:    message,
+	}
+
+	if sentAt, err := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64); err == nil {
+		letter.SentAt = time.Unix(0, sentAt*int64(time.Millisecond))
+	}
+
+	letter.ReceiveCount, _ = strconv.Atoi(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+
+	// messages delivered by sns carry the published message and
+	// its attributes in the notification
+	notification := new(snsNotification)
+	if err := json.Unmarshal([]byte(letter.Body), notification); err == nil && notification.Message != "" {
+		letter.Body = notification.Message
+
+		for name, attribute := range notification.MessageAttributes {
+			letter.Attributes[name] = attribute.Value
+		}
+	}
+
+	for name, attribute := range message.MessageAttributes {
+		letter.Attributes[name] = aws.StringValue(attribute.StringValue)
+	}
+
+	return letter
+}
+
+// size returns the size of the message as sqs counts it, its body
+// along with the name, type and value of its attributes
+func (letter *DeadLetter) size() int {
+	size := len(aws.StringValue(letter.message.Body))
+
+	for name, attribute := range letter.message.MessageAttributes {
+		size = size + len(name) + len(aws.StringValue(attribute.DataType)) +
+			len(aws.StringValue(attribute.StringValue)) + len(attribute.BinaryValue)
+	}
+
+	return size
+}
+
+func approximateMessages(client SQSClient, url string) (int, error) {
+	output, err := client.GetQueueAttributesWithContext(context.Background(), &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(url),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameApproximateNumberOfMessages}),
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(aws.StringValue(output.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]))
+}
@@ -0,0 +1,160 @@
+package core_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type deadLetterTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	now    time.Time
+	sqs    *standInSQS
+	queue  *core.DeadLetterQueue
+}
+
+const deadLetterQueueURL = "https://sqs.us-east-1.amazonaws.com/1/inventory-read_dlq"
+
+func TestDeadLetterTestSuite(t *testing.T) {
+	suite.Run(t, new(deadLetterTestSuite))
+}
+
+func (s *deadLetterTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+	s.now = time.Now()
+}
+
+func (s *deadLetterTestSuite) SetupTest() {
+	s.sqs = newStandInSQS()
+	s.sqs.waitUnit = time.Millisecond
+	s.queue = core.NewDeadLetterQueue(s.sqs, deadLetterQueueURL, subscriberQueueURL)
+}
+
+func (s *deadLetterTestSuite) TestFilterEmpty() {
+	s.assert.True(new(core.DeadLetterFilter).Empty())
+	s.assert.False((&core.DeadLetterFilter{Limit: 1}).Empty())
+	s.assert.False((&core.DeadLetterFilter{Contains: "id"}).Empty())
+
+	letter := &core.DeadLetter{Body: `{"id":"1"}`, SentAt: s.now.Add(-time.Hour)}
+	s.assert.True(new(core.DeadLetterFilter).Match(letter, s.now))
+}
+
+func (s *deadLetterTestSuite) TestFilterAge() {
+	letter := &core.DeadLetter{Body: `{"id":"1"}`, SentAt: s.now.Add(-time.Hour)}
+
+	s.assert.True((&core.DeadLetterFilter{OlderThan: time.Minute}).Match(letter, s.now))
+	s.assert.False((&core.DeadLetterFilter{OlderThan: 2 * time.Hour}).Match(letter, s.now))
+
+	s.assert.True((&core.DeadLetterFilter{NewerThan: 2 * time.Hour}).Match(letter, s.now))
+	s.assert.False((&core.DeadLetterFilter{NewerThan: time.Minute}).Match(letter, s.now))
+
+	s.assert.True((&core.DeadLetterFilter{OlderThan: time.Minute, NewerThan: 2 * time.Hour}).Match(letter, s.now))
+}
+
+func (s *deadLetterTestSuite) TestFilterContains() {
+	letter := &core.DeadLetter{Body: `{"id":"1","owner_id":"2"}`, SentAt: s.now}
+
+	s.assert.True((&core.DeadLetterFilter{Contains: `"owner_id":"2"`}).Match(letter, s.now))
+	s.assert.False((&core.DeadLetterFilter{Contains: `"owner_id":"3"`}).Match(letter, s.now))
+}
+
+func (s *deadLetterTestSuite) TestPeek() {
+	s.sqs.sendTo(deadLetterQueueURL, "1", `{"id":"1"}`, "")
+	s.sqs.sendTo(deadLetterQueueURL, "2", `{"id":"2"}`, "")
+
+	letters, err := s.queue.Peek(&core.DeadLetterFilter{Contains: `"id":"2"`})
+
+	s.assert.NoError(err)
+	s.assert.Equal("inventory-read_dlq", s.queue.Name())
+	s.assert.Len(letters, 1)
+	s.assert.Equal("2", letters[0].ID)
+	s.assert.Equal(`{"id":"2"}`, letters[0].Body)
+	s.assert.Len(s.sqs.bodies(deadLetterQueueURL), 2)
+	s.assert.Empty(s.sqs.deletedHandles())
+}
+
+func (s *deadLetterTestSuite) TestPurge() {
+	s.sqs.sendTo(deadLetterQueueURL, "1", `{"id":"1"}`, "")
+	s.sqs.sendTo(deadLetterQueueURL, "2", `{"id":"2"}`, "")
+	s.sqs.sendTo(deadLetterQueueURL, "3", `{"id":"3"}`, "")
+
+	purged, err := s.queue.Purge(&core.DeadLetterFilter{Limit: 2})
+
+	s.assert.NoError(err)
+	s.assert.Equal(2, purged)
+	s.assert.Equal([]string{"receipt-1", "receipt-2"}, s.sqs.deletedHandles())
+	s.assert.Len(s.sqs.bodies(deadLetterQueueURL), 1)
+	s.assert.Empty(s.sqs.purged)
+}
+
+func (s *deadLetterTestSuite) TestPurgeAll() {
+	s.sqs.sendTo(deadLetterQueueURL, "1", `{"id":"1"}`, "")
+	s.sqs.sendTo(deadLetterQueueURL, "2", `{"id":"2"}`, "")
+
+	purged, err := s.queue.Purge(new(core.DeadLetterFilter))
+
+	s.assert.NoError(err)
+	s.assert.Equal(2, purged)
+	s.assert.Equal([]string{deadLetterQueueURL}, s.sqs.purged)
+	s.assert.Empty(s.sqs.bodies(deadLetterQueueURL))
+}
+
+func (s *deadLetterTestSuite) TestRedrive() {
+	s.sqs.sendTo(deadLetterQueueURL, "1", `{"id":"1"}`, "item-1")
+	s.sqs.sendTo(deadLetterQueueURL, "2", `{"id":"2"}`, "")
+	s.sqs.sendTo(deadLetterQueueURL, "3", `{"id":"3"}`, "")
+
+	redriven, err := s.queue.Redrive(&core.DeadLetterFilter{Contains: `"id":"3"`})
+	s.assert.NoError(err)
+	s.assert.Equal(1, redriven)
+
+	redriven, err = s.queue.Redrive(new(core.DeadLetterFilter))
+	s.assert.NoError(err)
+	s.assert.Equal(2, redriven)
+
+	s.assert.Empty(s.sqs.bodies(deadLetterQueueURL))
+	s.assert.Len(s.sqs.bodies(subscriberQueueURL), 3)
+
+	// fifo messages keep their group, deduplicated by their id
+	entry := s.sqs.sent[1][0]
+	s.assert.Equal("item-1", aws.StringValue(entry.MessageGroupId))
+	s.assert.Equal("1", aws.StringValue(entry.MessageDeduplicationId))
+	s.assert.Nil(s.sqs.sent[1][1].MessageGroupId)
+}
+
+func (s *deadLetterTestSuite) TestRedriveSplitsBySize() {
+	body := strings.Repeat("a", 100*1024)
+
+	s.sqs.sendTo(deadLetterQueueURL, "1", body, "")
+	s.sqs.sendTo(deadLetterQueueURL, "2", body, "")
+	s.sqs.sendTo(deadLetterQueueURL, "3", body, "")
+
+	redriven, err := s.queue.Redrive(new(core.DeadLetterFilter))
+
+	s.assert.NoError(err)
+	s.assert.Equal(3, redriven)
+	s.assert.Len(s.sqs.sent, 2)
+	s.assert.Len(s.sqs.sent[0], 2)
+	s.assert.Len(s.sqs.sent[1], 1)
+}
+
+func (s *deadLetterTestSuite) TestRedriveSendFailed() {
+	s.sqs.sendTo(deadLetterQueueURL, "1", `{"id":"1"}`, "")
+	s.sqs.sendTo(deadLetterQueueURL, "2", `{"id":"2"}`, "")
+	s.sqs.sendErr = errors.New("send failed")
+
+	redriven, err := s.queue.Redrive(new(core.DeadLetterFilter))
+
+	s.assert.Equal(s.sqs.sendErr, err)
+	s.assert.Equal(0, redriven)
+	s.assert.Empty(s.sqs.deletedHandles())
+	s.assert.Len(s.sqs.bodies(deadLetterQueueURL), 2)
+	s.assert.Empty(s.sqs.bodies(subscriberQueueURL))
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoding of the data of published messages
+type Encoding string
+
+const (
+	// EncodingJSON marshals data with encoding/json, the default
+	EncodingJSON Encoding = "json"
+
+	// EncodingProtobuf marshals data implementing ProtoMarshaler to binary
+	// protobuf, base64 encoded as sns only carries text. Every broker encodes
+	// it the same way, so messages can be moved between them as they are
+	EncodingProtobuf Encoding = "protobuf"
+
+	// EncodingProtoJSON marshals data implementing ProtoMarshaler with protojson
+	EncodingProtoJSON Encoding = "protojson"
+
+	// ContentTypeAttribute message attribute carrying the content type of the
+	// data, subscribers decode messages without cloud event envelope by it
+	ContentTypeAttribute = "content_type"
+
+	// ContentTypeJSON content type of data encoded with EncodingJSON
+	ContentTypeJSON = "application/json"
+
+	// ContentTypeProtobuf content type of data encoded with EncodingProtobuf
+	ContentTypeProtobuf = "application/protobuf"
+
+	// ContentTypeProtoJSON content type of data encoded with EncodingProtoJSON
+	ContentTypeProtoJSON = "application/protobuf+json"
+)
+
+// ProtoMarshaler is implemented by data with a protobuf representation
+type ProtoMarshaler interface {
+	Proto() proto.Message
+}
+
+// ParseEncoding returns the Encoding named by s, json when s is empty
+func ParseEncoding(s string) (Encoding, error) {
+	switch encoding := Encoding(s); encoding {
+	case "":
+		return EncodingJSON, nil
+	case EncodingJSON, EncodingProtobuf, EncodingProtoJSON:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q", s)
+	}
+}
+
+// encodeMessage returns the body of a message publishing data and the content
+// type of its data. Data of cloud events is encoded with the envelope kept in
+// json, data without a protobuf representation is always encoded in json
+func encodeMessage(data interface{}, encoding Encoding) ([]byte, string, error) {
+	if event, ok := data.(*CloudEvent); ok {
+		return encodeCloudEvent(event, encoding)
+	}
+
+	message, ok := data.(ProtoMarshaler)
+	if !ok || encoding == EncodingJSON || encoding == "" {
+		body, err := json.Marshal(data)
+		return body, ContentTypeJSON, err
+	}
+
+	switch encoding {
+	case EncodingProtobuf:
+		body, err := proto.Marshal(message.Proto())
+		if err != nil {
+			return nil, "", err
+		}
+
+		return []byte(base64.StdEncoding.EncodeToString(body)), ContentTypeProtobuf, nil
+	case EncodingProtoJSON:
+		body, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message.Proto())
+		return body, ContentTypeProtoJSON, err
+	default:
+		return nil, "", fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+func encodeCloudEvent(event *CloudEvent, encoding Encoding) ([]byte, string, error) {
+	message, ok := event.Payload.(ProtoMarshaler)
+
+	if ok && encoding != EncodingJSON && encoding != "" {
+		encoded := *event
+
+		switch encoding {
+		case EncodingProtobuf:
+			data, err := proto.Marshal(message.Proto())
+			if err != nil {
+				return nil, "", err
+			}
+
+			encoded.Data = nil
+			encoded.DataBase64 = base64.StdEncoding.EncodeToString(data)
+			encoded.DataContentType = ContentTypeProtobuf
+		case EncodingProtoJSON:
+			data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message.Proto())
+			if err != nil {
+				return nil, "", err
+			}
+
+			encoded.Data = data
+			encoded.DataContentType = ContentTypeProtoJSON
+		default:
+			return nil, "", fmt.Errorf("unknown encoding %q", encoding)
+		}
+
+		event = &encoded
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, event.DataContentType, nil
+}
+
+// withContentType returns a copy of attributes carrying the content type
+func withContentType(attributes map[string]string, contentType string) map[string]string {
+	copied := make(map[string]string, len(attributes)+1)
+	for name, value := range attributes {
+		copied[name] = value
+	}
+
+	copied[ContentTypeAttribute] = contentType
+
+	return copied
+}
+
+// decodeMessage decodes the data of body into a new value of handleType by its
+// content type, taken from the cloud event envelope or, for messages without
+// one, from the attributes. Generated protobuf types decode json data as well
+func decodeMessage(body []byte, attributes map[string]string, handleType reflect.Type) (*Message, error) {
+	data, event := UnwrapCloudEvent(body)
+
+	contentType := attributes[ContentTypeAttribute]
+	if event != nil {
+		contentType = event.DataContentType
+	} else if contentType == ContentTypeProtobuf {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		data = decoded
+	}
+
+	value := reflect.New(handleType).Interface()
+	message, isProto := value.(proto.Message)
+
+	switch {
+	case contentType == ContentTypeProtobuf && !isProto:
+		return nil, fmt.Errorf("cannot decode protobuf data into %s", handleType)
+	case contentType == ContentTypeProtobuf:
+		if err := proto.Unmarshal(data, message); err != nil {
+			return nil, err
+		}
+	case isProto:
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, message); err != nil {
+			return nil, err
+		}
+	default:
+		if err := json.Unmarshal(data, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Message{Body: value, Attributes: attributes, CloudEvent: event}, nil
+}
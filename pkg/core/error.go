@@ -36,6 +36,18 @@ var (
 	// than the total quantity
 	ErrNotEnoughtItemsToLock = newError("not-enought-items-to-lock")
 
+	// ErrVersionConflict returned when an entity has been changed
+	// since the version the caller expected
+	ErrVersionConflict = newError("version-conflict")
+
+	// ErrMessageTooLarge returned when a message body does not fit in the
+	// message broker size limit and no claim check store is configured
+	ErrMessageTooLarge = newError("message-too-large")
+
+	// ErrSubscriberNotConfigured returned when running a subscriber without
+	// sqs client, or without resolver when its queue url is not given
+	ErrSubscriberNotConfigured = newError("subscriber-not-configured")
+
 	// ErrInvalidWantedItems returned when trying to create an trade for
 	// unexistent items or items belong to some other user
 	ErrInvalidWantedItems = newError("invalid-wanted-items")
@@ -48,10 +60,12 @@ type RestError struct {
 
 // ErrorStatusMap mapping between application erros and status codes
 var ErrorStatusMap = map[string]int{
-	ErrValidationFailed.Key:   http.StatusUnprocessableEntity,
-	ErrMalformedJSON.Key:      http.StatusUnprocessableEntity,
-	ErrInvalidCredentials.Key: http.StatusBadRequest,
-	ErrNotFound.Key:           http.StatusNotFound,
+	ErrValidationFailed.Key:      http.StatusUnprocessableEntity,
+	ErrMalformedJSON.Key:         http.StatusUnprocessableEntity,
+	ErrInvalidCredentials.Key:    http.StatusBadRequest,
+	ErrNotFound.Key:              http.StatusNotFound,
+	ErrNotEnoughtItemsToLock.Key: http.StatusConflict,
+	ErrVersionConflict.Key:       http.StatusConflict,
 }
 
 // HandleRestError handles applications errors using ErrorStatusMap
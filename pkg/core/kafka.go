@@ -0,0 +1,270 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// KafkaMaxMessageSize is the default message.max.bytes of kafka brokers, in bytes
+	KafkaMaxMessageSize = 1024 * 1024
+
+	kafkaMessageIDHeader = "message_id"
+
+	// kafkaBatchTimeout is how long the writer waits for more messages before
+	// writing a batch, kafka-go waits a second by default
+	kafkaBatchTimeout = 10 * time.Millisecond
+)
+
+// KafkaWriter writes messages to kafka, implemented by kafka.Writer
+type KafkaWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// KafkaReader reads the messages of a topic as part of a consumer group,
+// implemented by kafka.Reader
+type KafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// KafkaBroker publishes messages to kafka topics, partitioned by the value of
+// their MessageKeyAttribute so messages with the same key keep their order.
+// The other attributes are sent as headers
+type KafkaBroker struct {
+	writer    KafkaWriter
+	newReader func(groupID, topicID string) KafkaReader
+	encoding  Encoding
+}
+
+// KafkaBrokerOption ...
+type KafkaBrokerOption func(*KafkaBroker)
+
+// NewKafkaBroker ...
+func NewKafkaBroker(brokers []string, opts ...KafkaBrokerOption) *KafkaBroker {
+	b := &KafkaBroker{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+			// publishing waits for its message to be written, so the
+			// writer must not hold it waiting for a fuller batch
+			BatchTimeout: kafkaBatchTimeout,
+		},
+		newReader: func(groupID, topicID string) KafkaReader {
+			return kafka.NewReader(kafka.ReaderConfig{
+				Brokers: brokers,
+				GroupID: groupID,
+				Topic:   topicID,
+			})
+		},
+		encoding: EncodingJSON,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// WithKafkaWriter replaces the writer of the broker, used to run against stand-ins
+func WithKafkaWriter(writer KafkaWriter) KafkaBrokerOption {
+	return func(b *KafkaBroker) {
+		b.writer = writer
+	}
+}
+
+// WithKafkaReaders replaces how readers are created, used to run against stand-ins
+func WithKafkaReaders(newReader func(groupID, topicID string) KafkaReader) KafkaBrokerOption {
+	return func(b *KafkaBroker) {
+		b.newReader = newReader
+	}
+}
+
+// WithKafkaEncoding sets how the data of published messages is encoded
+func WithKafkaEncoding(encoding Encoding) KafkaBrokerOption {
+	return func(b *KafkaBroker) {
+		b.encoding = encoding
+	}
+}
+
+// MaxMessageSize returns the size limit of published messages, in bytes
+func (b *KafkaBroker) MaxMessageSize() int {
+	return KafkaMaxMessageSize
+}
+
+// MessageSize returns the size of data published with attributes,
+// the value of the message and its headers
+func (b *KafkaBroker) MessageSize(data interface{}, attributes map[string]string) (int, error) {
+	body, contentType, err := encodeMessage(data, b.encoding)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(body) + headersSize(withContentType(attributes, contentType)), nil
+}
+
+// Publish ...
+func (b *KafkaBroker) Publish(topicID string, data interface{}) (string, error) {
+	return b.PublishWihAttribrutes(topicID, data, nil)
+}
+
+// PublishWihAttribrutes ...
+func (b *KafkaBroker) PublishWihAttribrutes(topicID string, data interface{}, attributes map[string]string) (string, error) {
+	body, contentType, err := encodeMessage(data, b.encoding)
+	if err != nil {
+		return "", err
+	}
+
+	attributes = withContentType(attributes, contentType)
+
+	if len(body)+headersSize(attributes) > KafkaMaxMessageSize {
+		return "", ErrMessageTooLarge
+	}
+
+	messageID := uuid.NewString()
+
+	message := kafka.Message{
+		Topic:   topicID,
+		Value:   body,
+		Headers: []kafka.Header{{Key: kafkaMessageIDHeader, Value: []byte(messageID)}},
+	}
+
+	for name, value := range attributes {
+		if name == MessageKeyAttribute {
+			message.Key = []byte(value)
+			continue
+		}
+
+		message.Headers = append(message.Headers, kafka.Header{Key: name, Value: []byte(value)})
+	}
+
+	if err := b.writer.WriteMessages(context.Background(), message); err != nil {
+		return "", err
+	}
+
+	return messageID, nil
+}
+
+// Subscriber creates a Subscriber reading the topic as the consumer group
+// named by the subscriber id, configured by the same options as
+// NewMessageBrokerSubscriber, the aws sessions are not used
+func (b *KafkaBroker) Subscriber(opts ...MessageBrokerSubscriberOption) Subscriber {
+	return &kafkaSubscriber{
+		broker: b,
+		config: NewMessageBrokerSubscriber(opts...),
+	}
+}
+
+// Close flushes the pending messages
+func (b *KafkaBroker) Close() error {
+	return b.writer.Close()
+}
+
+// headersSize returns the size of the attributes sent as headers
+// or key, along with the message id header
+func headersSize(attributes map[string]string) int {
+	size := len(kafkaMessageIDHeader) + len(uuid.NewString())
+	for name, value := range attributes {
+		size = size + len(name) + len(value)
+	}
+
+	return size
+}
+
+type kafkaSubscriber struct {
+	broker *KafkaBroker
+	config *MessageBrokerSubscriber
+}
+
+// Run handles the messages one at a time until ctx is done, committing each
+// one once handled. Messages that cannot be parsed or fail more than the max
+// retries are moved to the subscriber_id_dlq topic
+func (s *kafkaSubscriber) Run(ctx context.Context) error {
+	reader := s.broker.newReader(s.config.subscriberID, s.config.topicID)
+	defer reader.Close()
+
+	logrus.Infof("starting consumer %s with topic %s in kafka", s.config.subscriberID, s.config.topicID)
+
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			logrus.Infof("stopped consumer %s with topic %s in kafka", s.config.subscriberID, s.config.topicID)
+			return nil
+		}
+
+		if err != nil {
+			logrus.WithError(err).
+				Errorf("error fetch message")
+			return err
+		}
+
+		// the fetched message is finished even once ctx is done
+		if err := s.handle(context.Background(), message); err != nil {
+			return err
+		}
+
+		if err := reader.CommitMessages(context.Background(), message); err != nil {
+			logrus.WithError(err).
+				Errorf("error commit message %d of partition %d", message.Offset, message.Partition)
+			return err
+		}
+	}
+}
+
+// handle only fails when the message could not be moved to the dead letter topic
+func (s *kafkaSubscriber) handle(ctx context.Context, message kafka.Message) error {
+	attributes := map[string]string{}
+	var messageID string
+
+	for _, header := range message.Headers {
+		if header.Key == kafkaMessageIDHeader {
+			messageID = string(header.Value)
+			continue
+		}
+
+		attributes[header.Key] = string(header.Value)
+	}
+
+	if len(message.Key) > 0 {
+		attributes[MessageKeyAttribute] = string(message.Key)
+	}
+
+	received, err := decodeMessage(message.Value, attributes, s.config.handleType)
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("cannot unmarshal message %s - sending to dlq", messageID)
+
+		return s.deadLetter(ctx, message)
+	}
+
+	received.ID = messageID
+
+	attempts, err := s.config.handleWithRetries(ctx, received)
+	if err == nil {
+		return nil
+	}
+
+	logrus.WithError(err).
+		Errorf("message %s failed %d times - sending to dlq", received.ID, attempts)
+
+	return s.deadLetter(ctx, message)
+}
+
+func (s *kafkaSubscriber) deadLetter(ctx context.Context, message kafka.Message) error {
+	return s.broker.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   fmt.Sprintf("%s_dlq", s.config.subscriberID),
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: message.Headers,
+	})
+}
@@ -0,0 +1,426 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type kafkaTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	kafka  *standInKafka
+	broker *core.KafkaBroker
+}
+
+type testEvent struct {
+	ID string `json:"id"`
+}
+
+func (e *testEvent) Proto() proto.Message {
+	return wrapperspb.String(e.ID)
+}
+
+func TestKafkaTestSuite(t *testing.T) {
+	suite.Run(t, new(kafkaTestSuite))
+}
+
+func (s *kafkaTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *kafkaTestSuite) SetupTest() {
+	s.kafka = newStandInKafka()
+	s.broker = core.NewKafkaBroker(nil, core.WithKafkaWriter(s.kafka), core.WithKafkaReaders(s.kafka.reader))
+}
+
+func (s *kafkaTestSuite) TestPublishKeyed() {
+	id := uuid.NewString()
+
+	messageID, err := s.broker.PublishWihAttribrutes("item-events", &testEvent{ID: id}, map[string]string{
+		core.MessageKeyAttribute: id,
+		"event_type":             "ItemCreated",
+	})
+
+	s.assert.NoError(err)
+	s.assert.NotEmpty(messageID)
+
+	messages := s.kafka.topics["item-events"]
+	s.assert.Len(messages, 1)
+	s.assert.Equal(id, string(messages[0].Key))
+	s.assert.JSONEq(`{"id":"`+id+`"}`, string(messages[0].Value))
+
+	headers := map[string]string{}
+	for _, header := range messages[0].Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	s.assert.Equal("ItemCreated", headers["event_type"])
+	s.assert.NotContains(headers, core.MessageKeyAttribute)
+}
+
+func (s *kafkaTestSuite) TestPublishTooLarge() {
+	_, err := s.broker.Publish("item-events", strings.Repeat("a", core.KafkaMaxMessageSize))
+
+	s.assert.Equal(core.ErrMessageTooLarge, err)
+	s.assert.Empty(s.kafka.topics["item-events"])
+}
+
+func (s *kafkaTestSuite) TestSubscriber() {
+	id := uuid.NewString()
+
+	messageID, err := s.broker.PublishWihAttribrutes("item-events", &testEvent{ID: id}, map[string]string{
+		core.MessageKeyAttribute: id,
+		"event_type":             "ItemCreated",
+	})
+	s.assert.NoError(err)
+
+	var received []*core.Message
+
+	err = s.broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithMessageHandler(func(m *core.Message) error {
+			received = append(received, m)
+			return nil
+		}),
+	).Run(context.Background())
+
+	s.assert.Equal(io.EOF, err)
+	s.assert.Len(received, 1)
+	s.assert.Equal(messageID, received[0].ID)
+	s.assert.Equal(id, received[0].Body.(*testEvent).ID)
+	s.assert.Equal(id, received[0].Attributes[core.MessageKeyAttribute])
+	s.assert.Equal("ItemCreated", received[0].Attributes["event_type"])
+	s.assert.Equal(1, s.kafka.committed["inventory-read"])
+	s.assert.Empty(s.kafka.topics["inventory-read_dlq"])
+}
+
+func (s *kafkaTestSuite) TestSubscriberCloudEvent() {
+	id := uuid.NewString()
+
+	_, err := s.broker.Publish("item-events", &core.CloudEvent{
+		SpecVersion:     core.CloudEventsSpecVersion,
+		ID:              "1",
+		Source:          "inventory-write",
+		Type:            "tradew.inventory.ItemCreated",
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Data:            json.RawMessage(`{"id":"` + id + `"}`),
+	})
+	s.assert.NoError(err)
+
+	// legacy messages are still decoded during the migration
+	_, err = s.broker.Publish("item-events", &testEvent{ID: id})
+	s.assert.NoError(err)
+
+	var received []*core.Message
+
+	err = s.broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithMessageHandler(func(m *core.Message) error {
+			received = append(received, m)
+			return nil
+		}),
+	).Run(context.Background())
+
+	s.assert.Equal(io.EOF, err)
+	s.assert.Len(received, 2)
+
+	s.assert.Equal(id, received[0].Body.(*testEvent).ID)
+	s.assert.Equal("tradew.inventory.ItemCreated", received[0].CloudEvent.Type)
+
+	s.assert.Equal(id, received[1].Body.(*testEvent).ID)
+	s.assert.Nil(received[1].CloudEvent)
+}
+
+func (s *kafkaTestSuite) TestSubscriberContentType() {
+	id := uuid.NewString()
+
+	event := &core.CloudEvent{
+		SpecVersion:     core.CloudEventsSpecVersion,
+		ID:              "1",
+		Source:          "inventory-write",
+		Type:            "tradew.inventory.ItemCreated",
+		Time:            time.Now(),
+		DataContentType: core.ContentTypeJSON,
+		Data:            json.RawMessage(`"` + id + `"`),
+		Payload:         &testEvent{ID: id},
+	}
+
+	for _, encoding := range []core.Encoding{core.EncodingJSON, core.EncodingProtobuf, core.EncodingProtoJSON} {
+		broker := core.NewKafkaBroker(nil, core.WithKafkaWriter(s.kafka), core.WithKafkaEncoding(encoding))
+
+		_, err := broker.Publish("item-events", event)
+		s.assert.NoError(err)
+
+		if encoding == core.EncodingJSON {
+			continue
+		}
+
+		// without envelope the content type is taken from the attributes
+		_, err = broker.Publish("item-events", &testEvent{ID: id})
+		s.assert.NoError(err)
+	}
+
+	var received []*core.Message
+
+	err := s.broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(wrapperspb.StringValue{})),
+		core.WithMessageHandler(func(m *core.Message) error {
+			received = append(received, m)
+			return nil
+		}),
+	).Run(context.Background())
+
+	s.assert.Equal(io.EOF, err)
+	s.assert.Empty(s.kafka.topics["inventory-read_dlq"])
+	s.assert.Len(received, 5)
+
+	contentTypes := []string{
+		core.ContentTypeJSON,
+		core.ContentTypeProtobuf, core.ContentTypeProtobuf,
+		core.ContentTypeProtoJSON, core.ContentTypeProtoJSON,
+	}
+
+	for i, message := range received {
+		s.assert.Equal(id, message.Body.(*wrapperspb.StringValue).Value)
+		s.assert.Equal(contentTypes[i], message.Attributes[core.ContentTypeAttribute])
+	}
+}
+
+func (s *kafkaTestSuite) TestSubscriberProtobufIntoStruct() {
+	broker := core.NewKafkaBroker(nil, core.WithKafkaWriter(s.kafka), core.WithKafkaEncoding(core.EncodingProtobuf))
+
+	_, err := broker.Publish("item-events", &testEvent{ID: uuid.NewString()})
+	s.assert.NoError(err)
+
+	err = s.broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithHandler(func(interface{}) error {
+			s.Fail("handler must not be called")
+			return nil
+		}),
+	).Run(context.Background())
+
+	s.assert.Equal(io.EOF, err)
+	s.assert.Len(s.kafka.topics["inventory-read_dlq"], 1)
+}
+
+func (s *kafkaTestSuite) TestSubscriberDeadLetter() {
+	_, err := s.broker.Publish("item-events", &testEvent{ID: uuid.NewString()})
+	s.assert.NoError(err)
+
+	attempts := 0
+
+	err = s.broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithMaxRetries(3),
+		core.WithHandler(func(interface{}) error {
+			attempts++
+			return errors.New("handler failed")
+		}),
+	).Run(context.Background())
+
+	s.assert.Equal(io.EOF, err)
+	s.assert.Equal(3, attempts)
+	s.assert.Len(s.kafka.topics["inventory-read_dlq"], 1)
+	s.assert.Equal(1, s.kafka.committed["inventory-read"])
+}
+
+func (s *kafkaTestSuite) TestSubscriberWithoutRetries() {
+	testCases := []struct {
+		name       string
+		handlerErr error
+		deadLetter int
+	}{
+		{
+			name: "handled",
+		},
+		{
+			name:       "failed",
+			handlerErr: errors.New("handler failed"),
+			deadLetter: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			s.SetupTest()
+
+			_, err := s.broker.Publish("item-events", &testEvent{ID: uuid.NewString()})
+			s.assert.NoError(err)
+
+			attempts := 0
+
+			// the handler is called once even without retries
+			err = s.broker.Subscriber(
+				core.WithSubscriberID("inventory-read"),
+				core.WithTopicID("item-events"),
+				core.WithType(reflect.TypeOf(testEvent{})),
+				core.WithMaxRetries(0),
+				core.WithHandler(func(interface{}) error {
+					attempts++
+					return tc.handlerErr
+				}),
+			).Run(context.Background())
+
+			s.assert.Equal(io.EOF, err)
+			s.assert.Equal(1, attempts)
+			s.assert.Len(s.kafka.topics["inventory-read_dlq"], tc.deadLetter)
+			s.assert.Equal(1, s.kafka.committed["inventory-read"])
+		})
+	}
+}
+
+func (s *kafkaTestSuite) TestSubscriberInvalidMessage() {
+	s.assert.NoError(s.kafka.WriteMessages(context.Background(), kafka.Message{
+		Topic: "item-events",
+		Value: []byte("not json"),
+	}))
+
+	err := s.broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithHandler(func(interface{}) error {
+			s.Fail("handler must not be called")
+			return nil
+		}),
+	).Run(context.Background())
+
+	s.assert.Equal(io.EOF, err)
+	s.assert.Len(s.kafka.topics["inventory-read_dlq"], 1)
+}
+
+// TestLocalBroker runs against the kafka brokers listed in KAFKA_BROKERS
+func (s *kafkaTestSuite) TestLocalBroker() {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		s.T().Skip("KAFKA_BROKERS is not set")
+	}
+
+	broker := core.NewKafkaBroker(strings.Split(brokers, ","))
+	defer broker.Close()
+
+	topicID := "inventory-test-" + uuid.NewString()
+	id := uuid.NewString()
+
+	_, err := broker.PublishWihAttribrutes(topicID, &testEvent{ID: id}, map[string]string{core.MessageKeyAttribute: id})
+	s.assert.NoError(err)
+
+	received := make(chan *core.Message, 1)
+
+	go broker.Subscriber(
+		core.WithSubscriberID(topicID),
+		core.WithTopicID(topicID),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithMessageHandler(func(m *core.Message) error {
+			received <- m
+			return nil
+		}),
+	).Run(context.Background())
+
+	select {
+	case m := <-received:
+		s.assert.Equal(id, m.Body.(*testEvent).ID)
+		s.assert.Equal(id, m.Attributes[core.MessageKeyAttribute])
+	case <-time.After(30 * time.Second):
+		s.Fail("message not received")
+	}
+}
+
+// standInKafka keeps the messages of each topic in memory, its readers
+// return io.EOF once they have read every message
+type standInKafka struct {
+	mu        sync.Mutex
+	topics    map[string][]kafka.Message
+	committed map[string]int
+}
+
+func newStandInKafka() *standInKafka {
+	return &standInKafka{
+		topics:    map[string][]kafka.Message{},
+		committed: map[string]int{},
+	}
+}
+
+func (k *standInKafka) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, message := range messages {
+		message.Offset = int64(len(k.topics[message.Topic]))
+		k.topics[message.Topic] = append(k.topics[message.Topic], message)
+	}
+
+	return nil
+}
+
+func (k *standInKafka) Close() error {
+	return nil
+}
+
+func (k *standInKafka) reader(groupID, topicID string) core.KafkaReader {
+	return &standInReader{kafka: k, groupID: groupID, topicID: topicID}
+}
+
+type standInReader struct {
+	kafka   *standInKafka
+	groupID string
+	topicID string
+	offset  int
+}
+
+func (r *standInReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.kafka.mu.Lock()
+	defer r.kafka.mu.Unlock()
+
+	messages := r.kafka.topics[r.topicID]
+	if r.offset >= len(messages) {
+		return kafka.Message{}, io.EOF
+	}
+
+	r.offset++
+
+	return messages[r.offset-1], nil
+}
+
+func (r *standInReader) CommitMessages(ctx context.Context, messages ...kafka.Message) error {
+	r.kafka.mu.Lock()
+	defer r.kafka.mu.Unlock()
+
+	for _, message := range messages {
+		r.kafka.committed[r.groupID] = int(message.Offset) + 1
+	}
+
+	return nil
+}
+
+func (r *standInReader) Close() error {
+	return nil
+}
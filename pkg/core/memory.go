@@ -0,0 +1,227 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryBroker is an in process Publisher, every subscriber of a topic
+// receives the messages published after it started running. Brokers created
+// with WithMemoryRetention also keep the published messages, so tests can
+// check them with Messages
+type MemoryBroker struct {
+	mu             sync.Mutex
+	maxMessageSize int
+	encoding       Encoding
+	retention      bool
+	sequence       int
+	messages       map[string][]*Message
+	queues         map[string][]*memoryQueue
+}
+
+// MemoryBrokerOption ...
+type MemoryBrokerOption func(*MemoryBroker)
+
+// NewMemoryBroker ...
+func NewMemoryBroker(opts ...MemoryBrokerOption) *MemoryBroker {
+	b := &MemoryBroker{
+		maxMessageSize: MaxMessageSize,
+		encoding:       EncodingJSON,
+		messages:       map[string][]*Message{},
+		queues:         map[string][]*memoryQueue{},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// WithMemoryMaxMessageSize sets the size limit of published messages
+func WithMemoryMaxMessageSize(size int) MemoryBrokerOption {
+	return func(b *MemoryBroker) {
+		if size > 0 {
+			b.maxMessageSize = size
+		}
+	}
+}
+
+// WithMemoryEncoding sets how the data of published messages is encoded
+func WithMemoryEncoding(encoding Encoding) MemoryBrokerOption {
+	return func(b *MemoryBroker) {
+		b.encoding = encoding
+	}
+}
+
+// WithMemoryRetention keeps every published message to be returned by
+// Messages, they are never removed so it is meant for tests
+func WithMemoryRetention() MemoryBrokerOption {
+	return func(b *MemoryBroker) {
+		b.retention = true
+	}
+}
+
+// MaxMessageSize returns the size limit of published messages, in bytes
+func (b *MemoryBroker) MaxMessageSize() int {
+	return b.maxMessageSize
+}
+
+// MessageSize returns the size of data published with attributes,
+// counted as sns counts it so chunks fit in both brokers
+func (b *MemoryBroker) MessageSize(data interface{}, attributes map[string]string) (int, error) {
+	body, contentType, err := encodeMessage(data, b.encoding)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(body) + attributesSize(withContentType(attributes, contentType)), nil
+}
+
+// Publish ...
+func (b *MemoryBroker) Publish(topicID string, data interface{}) (string, error) {
+	return b.PublishWihAttribrutes(topicID, data, nil)
+}
+
+// PublishWihAttribrutes ...
+func (b *MemoryBroker) PublishWihAttribrutes(topicID string, data interface{}, attributes map[string]string) (string, error) {
+	body, contentType, err := encodeMessage(data, b.encoding)
+	if err != nil {
+		return "", err
+	}
+
+	attributes = withContentType(attributes, contentType)
+
+	if len(body)+attributesSize(attributes) > b.maxMessageSize {
+		return "", ErrMessageTooLarge
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sequence++
+
+	message := &Message{
+		ID:         strconv.Itoa(b.sequence),
+		Body:       json.RawMessage(body),
+		Attributes: attributes,
+	}
+
+	if b.retention {
+		b.messages[topicID] = append(b.messages[topicID], message)
+	}
+
+	for _, queue := range b.queues[topicID] {
+		queue.push(message)
+	}
+
+	return message.ID, nil
+}
+
+// Messages returns the messages published to the topic, their Body holds
+// the published body as a json.RawMessage. It is always empty without
+// WithMemoryRetention
+func (b *MemoryBroker) Messages(topicID string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*Message(nil), b.messages[topicID]...)
+}
+
+// Subscriber creates a Subscriber of the broker configured by the same
+// options as NewMessageBrokerSubscriber, the aws sessions are not used
+func (b *MemoryBroker) Subscriber(opts ...MessageBrokerSubscriberOption) Subscriber {
+	return &memorySubscriber{
+		broker: b,
+		config: NewMessageBrokerSubscriber(opts...),
+	}
+}
+
+func (b *MemoryBroker) subscribe(topicID string) *memoryQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queue := &memoryQueue{notify: make(chan struct{}, 1)}
+	b.queues[topicID] = append(b.queues[topicID], queue)
+
+	return queue
+}
+
+// memoryQueue is an unbounded queue, so publishing never
+// waits for slow subscribers
+type memoryQueue struct {
+	mu       sync.Mutex
+	messages []*Message
+	notify   chan struct{}
+}
+
+func (q *memoryQueue) push(message *Message) {
+	q.mu.Lock()
+	q.messages = append(q.messages, message)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for a message until ctx is done, returning nil then
+func (q *memoryQueue) pop(ctx context.Context) *Message {
+	for {
+		q.mu.Lock()
+		if len(q.messages) > 0 {
+			message := q.messages[0]
+			q.messages = q.messages[1:]
+			q.mu.Unlock()
+			return message
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.notify:
+		}
+	}
+}
+
+type memorySubscriber struct {
+	broker *MemoryBroker
+	config *MessageBrokerSubscriber
+}
+
+// Run handles the messages of the topic one at a time until ctx is done, a
+// message failing more than the max retries is dropped as there is no dead
+// letter queue
+func (s *memorySubscriber) Run(ctx context.Context) error {
+	queue := s.broker.subscribe(s.config.topicID)
+
+	logrus.Infof("starting consumer %s with topic %s in memory", s.config.subscriberID, s.config.topicID)
+
+	for {
+		message := queue.pop(ctx)
+		if message == nil {
+			logrus.Infof("stopped consumer %s with topic %s in memory", s.config.subscriberID, s.config.topicID)
+			return nil
+		}
+
+		received, err := decodeMessage(message.Body.(json.RawMessage), message.Attributes, s.config.handleType)
+		if err != nil {
+			logrus.WithError(err).
+				Errorf("cannot unmarshal message %s - dropping it", message.ID)
+			continue
+		}
+
+		received.ID = message.ID
+
+		if attempts, err := s.config.handleWithRetries(ctx, received); err != nil {
+			logrus.WithError(err).
+				Errorf("message %s failed %d times - dropping it", message.ID, attempts)
+		}
+	}
+}
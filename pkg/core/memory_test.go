@@ -0,0 +1,174 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type memoryTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func TestMemoryTestSuite(t *testing.T) {
+	suite.Run(t, new(memoryTestSuite))
+}
+
+func (s *memoryTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *memoryTestSuite) TestPublishWithoutRetention() {
+	broker := core.NewMemoryBroker()
+
+	received := make(chan string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber := broker.Subscriber(
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithMessageHandler(func(m *core.Message) error {
+			received <- m.Body.(*testEvent).ID
+			return nil
+		}),
+	)
+
+	go subscriber.Run(ctx)
+
+	// the subscriber only receives messages published after it started
+	s.assert.Eventually(func() bool {
+		_, err := broker.Publish("item-events", &testEvent{ID: "1"})
+		s.assert.NoError(err)
+
+		select {
+		case id := <-received:
+			return id == "1"
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	s.assert.Empty(broker.Messages("item-events"))
+}
+
+func (s *memoryTestSuite) TestRetriesWithBackoff() {
+	testCases := []struct {
+		name       string
+		maxRetries int
+		failures   int
+		attempts   int
+		backoff    time.Duration
+	}{
+		{
+			name:       "without retries",
+			maxRetries: 0,
+			failures:   1,
+			attempts:   1,
+		},
+		{
+			name:       "succeeds after retries",
+			maxRetries: 3,
+			failures:   2,
+			attempts:   3,
+			backoff:    300 * time.Millisecond,
+		},
+		{
+			name:       "fails every retry",
+			maxRetries: 2,
+			failures:   5,
+			attempts:   2,
+			backoff:    100 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			broker := core.NewMemoryBroker()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ready := make(chan struct{}, 1)
+			attempts := make(chan time.Time, tc.failures+1)
+
+			subscriber := broker.Subscriber(
+				core.WithSubscriberID("inventory-read"),
+				core.WithTopicID("item-events"),
+				core.WithType(reflect.TypeOf(testEvent{})),
+				core.WithMaxRetries(tc.maxRetries),
+				core.WithMessageHandler(func(m *core.Message) error {
+					if m.Body.(*testEvent).ID == "ready" {
+						ready <- struct{}{}
+						return nil
+					}
+
+					attempts <- time.Now()
+					if len(attempts) <= tc.failures {
+						return errors.New("handler failed")
+					}
+
+					return nil
+				}),
+			)
+
+			go subscriber.Run(ctx)
+
+			// the subscriber only receives messages published after it started
+			s.Require().Eventually(func() bool {
+				_, err := broker.Publish("item-events", &testEvent{ID: "ready"})
+				s.assert.NoError(err)
+
+				select {
+				case <-ready:
+					return true
+				case <-time.After(10 * time.Millisecond):
+					return false
+				}
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// lets the other ready messages published be handled first
+			time.Sleep(50 * time.Millisecond)
+
+			published := time.Now()
+			_, err := broker.Publish("item-events", &testEvent{ID: "1"})
+			s.assert.NoError(err)
+
+			// any attempt past the expected ones shows up in the meantime
+			time.Sleep(tc.backoff + 500*time.Millisecond)
+
+			s.Require().Len(attempts, tc.attempts)
+
+			var last time.Time
+			for i := 0; i < tc.attempts; i++ {
+				last = <-attempts
+			}
+
+			s.assert.GreaterOrEqual(int64(last.Sub(published)), int64(tc.backoff))
+		})
+	}
+}
+
+func (s *memoryTestSuite) TestPublishWithRetention() {
+	broker := core.NewMemoryBroker(core.WithMemoryRetention())
+
+	_, err := broker.Publish("item-events", &testEvent{ID: "1"})
+	s.assert.NoError(err)
+
+	_, err = broker.Publish("item-events", &testEvent{ID: "2"})
+	s.assert.NoError(err)
+
+	messages := broker.Messages("item-events")
+	s.assert.Len(messages, 2)
+	s.assert.Equal("1", messages[0].ID)
+	s.assert.Equal("2", messages[1].ID)
+	s.assert.Empty(broker.Messages("items-updated"))
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"net/http"
 	"runtime/debug"
 	"time"
@@ -15,6 +16,20 @@ const (
 	CorrelationIDHeader = "X-Correlation-ID"
 )
 
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation id,
+// it is attached to the messages published because of the request
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, correlationID)
+}
+
+// CorrelationIDFromContext returns the correlation id set by WithCorrelationID
+func CorrelationIDFromContext(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey{}).(string)
+	return correlationID
+}
+
 // InternalErrorRecovery actions to perform after a panic from the server
 func InternalErrorRecovery() gin.RecoveryFunc {
 	return func(c *gin.Context, err interface{}) {
@@ -1,23 +1,106 @@
 package core
 
 import (
-	"encoding/json"
-	"strings"
+	"context"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 )
 
+// MaxMessageSize is the largest message accepted by SNS, in bytes
+const MaxMessageSize = 256 * 1024
+
+const attributeDataType = "String"
+
 // MessageBrokerProducer ...
 type MessageBrokerProducer struct {
-	snsSvc *sns.SNS
+	client         SNSClient
+	resolver       *Resolver
+	maxMessageSize int
+	claimCheck     *ClaimCheckStore
+	encoding       Encoding
 }
 
+// ProducerOption ...
+type ProducerOption func(*MessageBrokerProducer)
+
 // NewMessageBrokerProducer ...
-func NewMessageBrokerProducer(s *session.Session) *MessageBrokerProducer {
-	snsSvc := sns.New(s)
-	return &MessageBrokerProducer{snsSvc: snsSvc}
+func NewMessageBrokerProducer(s *session.Session, opts ...ProducerOption) *MessageBrokerProducer {
+	p := &MessageBrokerProducer{
+		maxMessageSize: MaxMessageSize,
+		encoding:       EncodingJSON,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.client == nil {
+		p.client = sns.New(s)
+	}
+
+	if p.resolver == nil {
+		p.resolver = NewResolver(nil, nil, WithResolverSNSClient(p.client))
+	}
+
+	return p
+}
+
+// WithSNSClient publishes with client instead of a client created from the session
+func WithSNSClient(client SNSClient) ProducerOption {
+	return func(p *MessageBrokerProducer) {
+		p.client = client
+	}
+}
+
+// WithResolver shares the topics resolved by the resolver,
+// by default the producer resolves them by itself
+func WithResolver(resolver *Resolver) ProducerOption {
+	return func(p *MessageBrokerProducer) {
+		p.resolver = resolver
+	}
+}
+
+// WithMaxMessageSize lowers the size limit of published messages
+func WithMaxMessageSize(size int) ProducerOption {
+	return func(p *MessageBrokerProducer) {
+		if size > 0 && size < MaxMessageSize {
+			p.maxMessageSize = size
+		}
+	}
+}
+
+// WithClaimCheck stores bodies bigger than the size limit in the claim check
+// store and publishes a pointer to them instead of failing
+func WithClaimCheck(store *ClaimCheckStore) ProducerOption {
+	return func(p *MessageBrokerProducer) {
+		p.claimCheck = store
+	}
+}
+
+// WithEncoding sets how the data of published messages is encoded, json
+// by default. Their content type is set in the ContentTypeAttribute
+func WithEncoding(encoding Encoding) ProducerOption {
+	return func(p *MessageBrokerProducer) {
+		p.encoding = encoding
+	}
+}
+
+// MaxMessageSize returns the size limit of published messages, in bytes
+func (p *MessageBrokerProducer) MaxMessageSize() int {
+	return p.maxMessageSize
+}
+
+// MessageSize returns the size of data published with attributes
+// as counted by sns, the attributes included
+func (p *MessageBrokerProducer) MessageSize(data interface{}, attributes map[string]string) (int, error) {
+	body, contentType, err := encodeMessage(data, p.encoding)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(body) + attributesSize(withContentType(attributes, contentType)), nil
 }
 
 // Publish ...
@@ -28,24 +111,71 @@ func (p *MessageBrokerProducer) Publish(topicID string, data interface{}) (strin
 
 // PublishWihAttribrutes ...
 func (p *MessageBrokerProducer) PublishWihAttribrutes(topicID string, data interface{}, attributes map[string]string) (string, error) {
-	body, err := json.Marshal(data)
+	body, contentType, err := encodeMessage(data, p.encoding)
 
 	if err != nil {
 		return "", err
 	}
 
-	topic, err := createTopicIfNotExists(p.snsSvc, topicID)
+	attributes = withContentType(attributes, contentType)
+
+	topic, err := p.resolver.TopicARN(topicID)
 
 	if err != nil {
 		return "", err
 	}
 
+	messageAttributes := make(map[string]*sns.MessageAttributeValue, len(attributes))
+
+	for name, value := range attributes {
+		messageAttributes[name] = &sns.MessageAttributeValue{
+			DataType:    aws.String(attributeDataType),
+			StringValue: aws.String(value),
+		}
+	}
+
+	if len(body)+attributesSize(attributes) > p.maxMessageSize {
+		if p.claimCheck == nil {
+			return "", ErrMessageTooLarge
+		}
+
+		// cloud events are stored along with their envelope
+		storedType := contentType
+		if _, ok := data.(*CloudEvent); ok {
+			storedType = CloudEventsContentType
+		}
+
+		body, err = p.claimCheck.Put(topicID, body, storedType)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	message := string(body)
 
-	output, err := p.snsSvc.Publish(&sns.PublishInput{
-		Message:  &message,
-		TopicArn: topic,
-	})
+	input := &sns.PublishInput{
+		Message:           &message,
+		TopicArn:          aws.String(topic),
+		MessageAttributes: messageAttributes,
+	}
+
+	// fifo topics keep the order by message key, messages
+	// without one all share the same group
+	if IsFIFO(topicID) {
+		input.MessageGroupId = aws.String(topicID)
+
+		if key := attributes[MessageKeyAttribute]; key != "" {
+			input.MessageGroupId = aws.String(key)
+		}
+
+		// cloud events published again keep their id, so they are deduplicated
+		// by it. Other messages fall back to the content based deduplication
+		if event, ok := data.(*CloudEvent); ok && event.ID != "" {
+			input.MessageDeduplicationId = aws.String(event.ID)
+		}
+	}
+
+	output, err := p.client.PublishWithContext(context.Background(), input)
 
 	if err != nil {
 		return "", err
@@ -55,31 +185,45 @@ func (p *MessageBrokerProducer) PublishWihAttribrutes(topicID string, data inter
 
 }
 
-func createTopicIfNotExists(snsSvc *sns.SNS, id string) (*string, error) {
-	var topicArn *string
+// attributesSize returns the size sns counts for the attributes of a message,
+// their names, data types and values
+func attributesSize(attributes map[string]string) int {
+	size := 0
+	for name, value := range attributes {
+		size = size + len(name) + len(attributeDataType) + len(value)
+	}
 
-	allTopics, _ := snsSvc.ListTopics(&sns.ListTopicsInput{})
+	return size
+}
 
-	for _, t := range allTopics.Topics {
-		splitTopic := strings.Split(*t.TopicArn, ":")
-		strTopic := splitTopic[len(splitTopic)-1]
-		if strings.Compare(strTopic, id) == 0 {
-			topicArn = t.TopicArn
-			break
+// ChunkBySize splits the elements of a json array into consecutive chunks that
+// fit in limit bytes once marshaled. sizes holds the marshaled size of each
+// element and overhead the size of the message around the array, the returned
+// slice holds how many elements go in each chunk. An element that does not
+// fit by itself gets a chunk of its own, left to the claim check
+func ChunkBySize(sizes []int, overhead, limit int) []int {
+	var chunks []int
+	var count, size int
+
+	for _, elementSize := range sizes {
+		// elements after the first are preceded by a comma
+		if count > 0 && size+1+elementSize > limit {
+			chunks = append(chunks, count)
+			count = 0
 		}
-	}
 
-	if topicArn != nil {
-		return topicArn, nil
-	}
+		if count == 0 {
+			size = overhead + elementSize
+		} else {
+			size = size + 1 + elementSize
+		}
 
-	topic, err := snsSvc.CreateTopic(&sns.CreateTopicInput{
-		Name: aws.String(id),
-	})
+		count++
+	}
 
-	if err != nil {
-		return nil, err
+	if count > 0 {
+		chunks = append(chunks, count)
 	}
 
-	return topic.TopicArn, nil
+	return chunks
 }
@@ -0,0 +1,133 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type producerTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func TestProducerTestSuite(t *testing.T) {
+	suite.Run(t, new(producerTestSuite))
+}
+
+func (s *producerTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *producerTestSuite) TestPublishAttributes() {
+	testCases := []struct {
+		name       string
+		topic      string
+		encoding   core.Encoding
+		attributes map[string]string
+		expected   map[string]string
+		group      string
+	}{
+		{
+			name:     "without attributes",
+			topic:    "item-events",
+			encoding: core.EncodingJSON,
+			expected: map[string]string{core.ContentTypeAttribute: core.ContentTypeJSON},
+		},
+		{
+			name:       "with attributes",
+			topic:      "item-events",
+			encoding:   core.EncodingProtobuf,
+			attributes: map[string]string{"event_type": "ItemCreated", core.MessageKeyAttribute: "1"},
+			expected: map[string]string{
+				"event_type":              "ItemCreated",
+				core.MessageKeyAttribute:  "1",
+				core.ContentTypeAttribute: core.ContentTypeProtobuf,
+			},
+		},
+		{
+			name:       "fifo topic with message key",
+			topic:      "item-events.fifo",
+			encoding:   core.EncodingProtoJSON,
+			attributes: map[string]string{core.MessageKeyAttribute: "1"},
+			expected: map[string]string{
+				core.MessageKeyAttribute:  "1",
+				core.ContentTypeAttribute: core.ContentTypeProtoJSON,
+			},
+			group: "1",
+		},
+		{
+			name:     "fifo topic without message key",
+			topic:    "item-events.fifo",
+			encoding: core.EncodingJSON,
+			expected: map[string]string{core.ContentTypeAttribute: core.ContentTypeJSON},
+			group:    "item-events.fifo",
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			client := newStandInSNS(100, tc.topic)
+			producer := core.NewMessageBrokerProducer(nil, core.WithSNSClient(client), core.WithEncoding(tc.encoding))
+
+			_, err := producer.PublishWihAttribrutes(tc.topic, &testEvent{ID: "1"}, tc.attributes)
+
+			s.assert.NoError(err)
+			s.Require().Len(client.published, 1)
+
+			input := client.published[0]
+			s.assert.Equal(topicARNPrefix+tc.topic, aws.StringValue(input.TopicArn))
+			s.assert.Equal(tc.group, aws.StringValue(input.MessageGroupId))
+			s.assert.Nil(input.MessageDeduplicationId)
+			s.assert.Len(input.MessageAttributes, len(tc.expected))
+
+			for name, value := range tc.expected {
+				s.Require().Contains(input.MessageAttributes, name)
+				s.assert.Equal("String", aws.StringValue(input.MessageAttributes[name].DataType))
+				s.assert.Equal(value, aws.StringValue(input.MessageAttributes[name].StringValue))
+			}
+		})
+	}
+}
+
+func (s *producerTestSuite) TestPublishDeduplicationID() {
+	testCases := []struct {
+		name          string
+		topic         string
+		data          interface{}
+		deduplication string
+	}{
+		{
+			name:          "cloud event to fifo topic",
+			topic:         "item-events.fifo",
+			data:          &core.CloudEvent{ID: "42", Data: []byte(`{"id":"1"}`), DataContentType: core.ContentTypeJSON},
+			deduplication: "42",
+		},
+		{
+			name:  "cloud event to standard topic",
+			topic: "item-events",
+			data:  &core.CloudEvent{ID: "42", Data: []byte(`{"id":"1"}`), DataContentType: core.ContentTypeJSON},
+		},
+		{
+			name:  "message to fifo topic",
+			topic: "item-events.fifo",
+			data:  &testEvent{ID: "1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			client := newStandInSNS(100, tc.topic)
+			producer := core.NewMessageBrokerProducer(nil, core.WithSNSClient(client))
+
+			_, err := producer.Publish(tc.topic, tc.data)
+
+			s.assert.NoError(err)
+			s.Require().Len(client.published, 1)
+			s.assert.Equal(tc.deduplication, aws.StringValue(client.published[0].MessageDeduplicationId))
+		})
+	}
+}
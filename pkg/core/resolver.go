@@ -0,0 +1,229 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const fifoSuffix = ".fifo"
+
+// IsFIFO reports whether the topic or queue named name is a fifo one, which
+// keeps the order of the messages sharing a message group
+func IsFIFO(name string) bool {
+	return strings.HasSuffix(name, fifoSuffix)
+}
+
+// SNSClient finds, creates, subscribes and publishes to topics, implemented by sns.SNS
+type SNSClient interface {
+	ListTopicsWithContext(ctx aws.Context, input *sns.ListTopicsInput, opts ...request.Option) (*sns.ListTopicsOutput, error)
+	CreateTopicWithContext(ctx aws.Context, input *sns.CreateTopicInput, opts ...request.Option) (*sns.CreateTopicOutput, error)
+	SubscribeWithContext(ctx aws.Context, input *sns.SubscribeInput, opts ...request.Option) (*sns.SubscribeOutput, error)
+	PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error)
+}
+
+// Resolver resolves topic and queue names to their arn and url, creating
+// missing topics. Resolved names are cached, so in steady state publishing
+// does not call sns for anything else than the message itself
+type Resolver struct {
+	snsClient SNSClient
+	sqsClient SQSClient
+
+	mu     sync.Mutex
+	topics map[string]string
+	queues map[string]string
+}
+
+// ResolverOption ...
+type ResolverOption func(*Resolver)
+
+// NewResolver creates a resolver, sessionSQS may be nil when only topics are
+// resolved, and both sessions when the clients are given as options
+func NewResolver(sessionSNS, sessionSQS *session.Session, opts ...ResolverOption) *Resolver {
+	r := &Resolver{
+		topics: map[string]string{},
+		queues: map[string]string{},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.snsClient == nil && sessionSNS != nil {
+		r.snsClient = sns.New(sessionSNS)
+	}
+
+	if r.sqsClient == nil && sessionSQS != nil {
+		r.sqsClient = sqs.New(sessionSQS)
+	}
+
+	return r
+}
+
+// WithResolverSNSClient resolves topics with client instead
+// of a client created from the sns session
+func WithResolverSNSClient(client SNSClient) ResolverOption {
+	return func(r *Resolver) {
+		r.snsClient = client
+	}
+}
+
+// WithResolverSQSClient resolves queues with client instead
+// of a client created from the sqs session
+func WithResolverSQSClient(client SQSClient) ResolverOption {
+	return func(r *Resolver) {
+		r.sqsClient = client
+	}
+}
+
+// TopicARN returns the arn of the topic named name, creating it when it does not exist
+func (r *Resolver) TopicARN(name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if arn, ok := r.topics[name]; ok {
+		return arn, nil
+	}
+
+	arn, err := r.findTopic(name)
+	if err != nil {
+		return "", err
+	}
+
+	if arn == "" {
+		input := &sns.CreateTopicInput{Name: aws.String(name)}
+
+		// messages published with a deduplication id are deduplicated by it,
+		// the ones without by their content
+		if IsFIFO(name) {
+			input.Attributes = map[string]*string{
+				"FifoTopic":                 aws.String("true"),
+				"ContentBasedDeduplication": aws.String("true"),
+			}
+		}
+
+		topic, err := r.snsClient.CreateTopicWithContext(context.Background(), input)
+
+		if err != nil {
+			return "", err
+		}
+
+		arn = aws.StringValue(topic.TopicArn)
+	}
+
+	r.topics[name] = arn
+
+	return arn, nil
+}
+
+// QueueURL returns the url of the queue named name,
+// found is false when the queue does not exist
+func (r *Resolver) QueueURL(name string) (url string, found bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if url, ok := r.queues[name]; ok {
+		return url, true, nil
+	}
+
+	output, err := r.sqsClient.GetQueueUrlWithContext(context.Background(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(name),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	url = aws.StringValue(output.QueueUrl)
+	r.queues[name] = url
+
+	return url, true, nil
+}
+
+// CreateQueue creates the queue named name and returns its url
+func (r *Resolver) CreateQueue(name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	input := &sqs.CreateQueueInput{QueueName: aws.String(name)}
+
+	if IsFIFO(name) {
+		input.Attributes = map[string]*string{
+			sqs.QueueAttributeNameFifoQueue: aws.String("true"),
+		}
+	}
+
+	output, err := r.sqsClient.CreateQueueWithContext(context.Background(), input)
+
+	if err != nil {
+		return "", err
+	}
+
+	url := aws.StringValue(output.QueueUrl)
+	r.queues[name] = url
+
+	return url, nil
+}
+
+// Subscribe subscribes the queue with arn queueARN to the topic with arn topicARN
+func (r *Resolver) Subscribe(topicARN, queueARN string) error {
+	_, err := r.snsClient.SubscribeWithContext(context.Background(), &sns.SubscribeInput{
+		TopicArn: aws.String(topicARN),
+		Protocol: aws.String("sqs"),
+		Endpoint: aws.String(queueARN),
+	})
+
+	return err
+}
+
+// QueueARN returns the arn of the queue at url
+func (r *Resolver) QueueARN(url string) (string, error) {
+	output, err := r.sqsClient.GetQueueAttributesWithContext(context.Background(), &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(url),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.Attributes[sqs.QueueAttributeNameQueueArn]), nil
+}
+
+// findTopic goes through every page of topics, returning
+// an empty arn when there is no topic named name
+func (r *Resolver) findTopic(name string) (string, error) {
+	input := &sns.ListTopicsInput{}
+
+	for {
+		page, err := r.snsClient.ListTopicsWithContext(context.Background(), input)
+		if err != nil {
+			return "", err
+		}
+
+		for _, topic := range page.Topics {
+			// arns end with the topic name, arn:aws:sns:region:account:name
+			topicArn := aws.StringValue(topic.TopicArn)
+			if topicArn[strings.LastIndex(topicArn, ":")+1:] == name {
+				return topicArn, nil
+			}
+		}
+
+		if aws.StringValue(page.NextToken) == "" {
+			return "", nil
+		}
+
+		input.NextToken = page.NextToken
+	}
+}
@@ -0,0 +1,310 @@
+package core_test
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const topicARNPrefix = "arn:aws:sns:us-east-1:1:"
+
+type resolverTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func TestResolverTestSuite(t *testing.T) {
+	suite.Run(t, new(resolverTestSuite))
+}
+
+func (s *resolverTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *resolverTestSuite) TestTopicARN() {
+	topics := []string{"item-events", "items-updated", "other-items-deleted", "items-deleted.fifo", "user-deleted"}
+
+	testCases := []struct {
+		name    string
+		topic   string
+		arn     string
+		tokens  []string
+		created map[string]*string
+	}{
+		{
+			name:   "first page",
+			topic:  "items-updated",
+			arn:    topicARNPrefix + "items-updated",
+			tokens: []string{""},
+		},
+		{
+			name:   "following page",
+			topic:  "user-deleted",
+			arn:    topicARNPrefix + "user-deleted",
+			tokens: []string{"", "2", "4"},
+		},
+		{
+			name:   "fifo topic",
+			topic:  "items-deleted.fifo",
+			arn:    topicARNPrefix + "items-deleted.fifo",
+			tokens: []string{"", "2"},
+		},
+		{
+			name:    "missing topic",
+			topic:   "items-deleted",
+			arn:     topicARNPrefix + "items-deleted",
+			tokens:  []string{"", "2", "4"},
+			created: map[string]*string{},
+		},
+		{
+			name:   "missing fifo topic",
+			topic:  "item-events.fifo",
+			arn:    topicARNPrefix + "item-events.fifo",
+			tokens: []string{"", "2", "4"},
+			created: map[string]*string{
+				"FifoTopic":                 aws.String("true"),
+				"ContentBasedDeduplication": aws.String("true"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			client := newStandInSNS(2, topics...)
+			resolver := core.NewResolver(nil, nil, core.WithResolverSNSClient(client))
+
+			arn, err := resolver.TopicARN(tc.topic)
+
+			s.assert.NoError(err)
+			s.assert.Equal(tc.arn, arn)
+			s.assert.Equal(tc.tokens, client.tokens)
+
+			if tc.created == nil {
+				s.assert.Empty(client.created)
+			} else {
+				s.assert.Len(client.created, 1)
+				s.assert.Equal(tc.topic, aws.StringValue(client.created[0].Name))
+				s.assert.Equal(len(tc.created), len(client.created[0].Attributes))
+
+				for name, value := range tc.created {
+					s.assert.Equal(aws.StringValue(value), aws.StringValue(client.created[0].Attributes[name]))
+				}
+			}
+
+			// resolved topics are cached
+			arn, err = resolver.TopicARN(tc.topic)
+
+			s.assert.NoError(err)
+			s.assert.Equal(tc.arn, arn)
+			s.assert.Equal(tc.tokens, client.tokens)
+			s.assert.LessOrEqual(len(client.created), 1)
+		})
+	}
+}
+
+func (s *resolverTestSuite) TestTopicARNListFailed() {
+	client := newStandInSNS(2, "item-events", "items-updated", "items-deleted")
+	client.listErr = map[string]error{"2": errors.New("list failed")}
+
+	resolver := core.NewResolver(nil, nil, core.WithResolverSNSClient(client))
+
+	_, err := resolver.TopicARN("items-deleted")
+	s.assert.Equal(client.listErr["2"], err)
+	s.assert.Empty(client.created)
+
+	// failures are not cached
+	delete(client.listErr, "2")
+
+	arn, err := resolver.TopicARN("items-deleted")
+	s.assert.NoError(err)
+	s.assert.Equal(topicARNPrefix+"items-deleted", arn)
+}
+
+func (s *resolverTestSuite) TestQueueURL() {
+	testCases := []struct {
+		name    string
+		queues  []string
+		queue   string
+		url     string
+		found   bool
+		lookups []string
+	}{
+		{
+			name:    "existing queue",
+			queues:  []string{"inventory-read"},
+			queue:   "inventory-read",
+			url:     "https://sqs.us-east-1.amazonaws.com/1/inventory-read",
+			found:   true,
+			lookups: []string{"inventory-read"},
+		},
+		{
+			name:    "missing queue",
+			queues:  []string{"inventory-read"},
+			queue:   "inventory-read_dlq",
+			found:   false,
+			lookups: []string{"inventory-read_dlq", "inventory-read_dlq"},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			client := newStandInSQS()
+			for _, queue := range tc.queues {
+				client.urls[queue] = "https://sqs.us-east-1.amazonaws.com/1/" + queue
+			}
+
+			resolver := core.NewResolver(nil, nil, core.WithResolverSQSClient(client))
+
+			// found queues are cached, missing queues are looked up again
+			for i := 0; i < 2; i++ {
+				url, found, err := resolver.QueueURL(tc.queue)
+
+				s.assert.NoError(err)
+				s.assert.Equal(tc.url, url)
+				s.assert.Equal(tc.found, found)
+			}
+
+			s.assert.Equal(tc.lookups, client.lookups)
+		})
+	}
+}
+
+func (s *resolverTestSuite) TestCreateQueue() {
+	testCases := []struct {
+		name       string
+		queue      string
+		attributes map[string]*string
+	}{
+		{
+			name:  "standard queue",
+			queue: "inventory-read",
+		},
+		{
+			name:  "fifo queue",
+			queue: "inventory-read.fifo",
+			attributes: map[string]*string{
+				sqs.QueueAttributeNameFifoQueue: aws.String("true"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			client := newStandInSQS()
+			resolver := core.NewResolver(nil, nil, core.WithResolverSQSClient(client))
+
+			url, err := resolver.CreateQueue(tc.queue)
+
+			s.assert.NoError(err)
+			s.assert.Equal("https://sqs.us-east-1.amazonaws.com/1/"+tc.queue, url)
+			s.assert.Len(client.created, 1)
+			s.assert.Equal(tc.attributes, client.created[0].Attributes)
+
+			// created queues are cached
+			cached, found, err := resolver.QueueURL(tc.queue)
+
+			s.assert.NoError(err)
+			s.assert.True(found)
+			s.assert.Equal(url, cached)
+			s.assert.Empty(client.lookups)
+
+			arn, err := resolver.QueueARN(url)
+
+			s.assert.NoError(err)
+			s.assert.Equal("arn:aws:sqs:us-east-1:1:"+tc.queue, arn)
+		})
+	}
+}
+
+// standInSNS lists its topics in pages of pageSize, the next token being the
+// index of the first topic of the next page. Created topics are added to them
+type standInSNS struct {
+	mu        sync.Mutex
+	pageSize  int
+	arns      []string
+	tokens    []string
+	created   []*sns.CreateTopicInput
+	published []*sns.PublishInput
+	// subscriptions holds the arns of the queues subscribed to each topic
+	subscriptions map[string][]string
+	listErr       map[string]error
+}
+
+func newStandInSNS(pageSize int, topics ...string) *standInSNS {
+	client := &standInSNS{pageSize: pageSize, listErr: map[string]error{}, subscriptions: map[string][]string{}}
+
+	for _, topic := range topics {
+		client.arns = append(client.arns, topicARNPrefix+topic)
+	}
+
+	return client
+}
+
+func (c *standInSNS) ListTopicsWithContext(ctx aws.Context, input *sns.ListTopicsInput, opts ...request.Option) (*sns.ListTopicsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token := aws.StringValue(input.NextToken)
+	c.tokens = append(c.tokens, token)
+
+	if err := c.listErr[token]; err != nil {
+		return nil, err
+	}
+
+	start, _ := strconv.Atoi(token)
+	end := start + c.pageSize
+
+	output := new(sns.ListTopicsOutput)
+
+	if end < len(c.arns) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	} else {
+		end = len(c.arns)
+	}
+
+	for _, arn := range c.arns[start:end] {
+		output.Topics = append(output.Topics, &sns.Topic{TopicArn: aws.String(arn)})
+	}
+
+	return output, nil
+}
+
+func (c *standInSNS) CreateTopicWithContext(ctx aws.Context, input *sns.CreateTopicInput, opts ...request.Option) (*sns.CreateTopicOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	arn := topicARNPrefix + aws.StringValue(input.Name)
+
+	c.created = append(c.created, input)
+	c.arns = append(c.arns, arn)
+
+	return &sns.CreateTopicOutput{TopicArn: aws.String(arn)}, nil
+}
+
+func (c *standInSNS) SubscribeWithContext(ctx aws.Context, input *sns.SubscribeInput, opts ...request.Option) (*sns.SubscribeOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	topic := aws.StringValue(input.TopicArn)
+	c.subscriptions[topic] = append(c.subscriptions[topic], aws.StringValue(input.Endpoint))
+
+	return &sns.SubscribeOutput{SubscriptionArn: aws.String(topic + ":" + strconv.Itoa(len(c.subscriptions[topic])))}, nil
+}
+
+func (c *standInSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.published = append(c.published, input)
+
+	return &sns.PublishOutput{MessageId: aws.String(strconv.Itoa(len(c.published)))}, nil
+}
@@ -1,14 +1,22 @@
 package core
 
+import "time"
+
 // Settings ...
 type Settings struct {
-	Port     int32           `yaml:"port"`
-	GRPCPort int32           `yaml:"grpc_port"`
-	JWT      *JWT            `yaml:"jwt"`
-	SQS      *SessionConfig  `yaml:"sqs"`
-	SNS      *SessionConfig  `yaml:"sns"`
-	Postgres *PostgresConfig `yaml:"postgres"`
-	Events   *Events         `yaml:"events"`
+	Port       int32             `yaml:"port"`
+	Broker     *BrokerConfig     `yaml:"broker"`
+	GRPCPort   int32             `yaml:"grpc_port"`
+	JWT        *JWT              `yaml:"jwt"`
+	SQS        *SessionConfig    `yaml:"sqs"`
+	SNS        *SessionConfig    `yaml:"sns"`
+	S3         *SessionConfig    `yaml:"s3"`
+	Postgres   *PostgresConfig   `yaml:"postgres"`
+	Events     *Events           `yaml:"events"`
+	Locks      *LocksConfig      `yaml:"locks"`
+	Dispatcher *DispatcherConfig `yaml:"dispatcher"`
+	Users      *UsersConfig      `yaml:"users"`
+	Subscriber *SubscriberConfig `yaml:"subscriber"`
 }
 
 // JWT ...
@@ -16,6 +24,20 @@ type JWT struct {
 	Secret string `yaml:"secret"`
 }
 
+// BrokerConfig ...
+type BrokerConfig struct {
+	// Type is either sns, memory or kafka, sns when not set
+	Type string `yaml:"type"`
+	// Encoding of the published events, either json, protobuf or protojson
+	Encoding string       `yaml:"encoding"`
+	Kafka    *KafkaConfig `yaml:"kafka"`
+}
+
+// KafkaConfig ...
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+}
+
 // SessionConfig ...
 type SessionConfig struct {
 	Region   string `yaml:"region"`
@@ -23,6 +45,8 @@ type SessionConfig struct {
 	Path     string `yaml:"path"`
 	Profile  string `yaml:"profile"`
 	Fake     bool   `yaml:"fake"`
+	// Bucket is only used by s3
+	Bucket string `yaml:"bucket"`
 }
 
 // PostgresConfig ...
@@ -37,4 +61,42 @@ type PostgresConfig struct {
 // Events ...
 type Events struct {
 	ItemsUpdated string `yaml:"items-updated"`
+	ItemsDeleted string `yaml:"items-deleted"`
+	ItemEvents   string `yaml:"item-events"`
+	// TradeCancelled and TradeRejected are published by the trade service,
+	// the topics left empty are not subscribed to
+	TradeCancelled string `yaml:"trade-cancelled"`
+	TradeRejected  string `yaml:"trade-rejected"`
+	// UserDeleted is published by the user service
+	UserDeleted string `yaml:"user-deleted"`
+}
+
+// LocksConfig ...
+type LocksConfig struct {
+	DefaultTTL time.Duration `yaml:"default-ttl"`
+	// ReleaseBatchSize is how many items with expired locks
+	// are released in each transaction
+	ReleaseBatchSize int `yaml:"release-batch-size"`
+}
+
+// DispatcherConfig ...
+type DispatcherConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch-size"`
+	Lease     time.Duration `yaml:"lease"`
+}
+
+// UsersConfig ...
+type UsersConfig struct {
+	// DeletionPolicy is either delete or anonymize, delete when not set
+	DeletionPolicy string `yaml:"deletion-policy"`
+}
+
+// SubscriberConfig only applies to sns subscribers,
+// the fields left empty keep the defaults of the subscriber
+type SubscriberConfig struct {
+	Concurrency       int           `yaml:"concurrency"`
+	WaitTime          time.Duration `yaml:"wait-time"`
+	MaxMessages       int           `yaml:"max-messages"`
+	VisibilityTimeout time.Duration `yaml:"visibility-timeout"`
 }
@@ -1,30 +1,86 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/sirupsen/logrus"
 )
 
+// Message is a received message, Body holds a pointer to the type
+// given by WithType and Attributes the attributes it was published with.
+// CloudEvent holds the envelope of the body, nil for legacy messages
+type Message struct {
+	ID         string
+	Body       interface{}
+	Attributes map[string]string
+	CloudEvent *CloudEvent
+}
+
+const (
+	// sqsMaxWaitTime is the longest receive requests can wait for messages
+	sqsMaxWaitTime = 20 * time.Second
+
+	defaultVisibilityTimeout = 30 * time.Second
+
+	// retryMinBackoff is the wait before retrying a failed message, doubled
+	// after every attempt up to retryMaxBackoff
+	retryMinBackoff = 100 * time.Millisecond
+	retryMaxBackoff = 5 * time.Second
+)
+
+// SQSClient finds and creates queues, and receives, hides, sends and deletes
+// their messages, implemented by sqs.SQS
+type SQSClient interface {
+	GetQueueUrlWithContext(ctx aws.Context, input *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error)
+	CreateQueueWithContext(ctx aws.Context, input *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error)
+	SetQueueAttributesWithContext(ctx aws.Context, input *sqs.SetQueueAttributesInput, opts ...request.Option) (*sqs.SetQueueAttributesOutput, error)
+	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibilityBatchWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityBatchInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error)
+	PurgeQueueWithContext(ctx aws.Context, input *sqs.PurgeQueueInput, opts ...request.Option) (*sqs.PurgeQueueOutput, error)
+	GetQueueAttributesWithContext(ctx aws.Context, input *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// snsNotification is the body of messages delivered by sns to sqs
+type snsNotification struct {
+	Message           string `json:"Message"`
+	MessageAttributes map[string]struct {
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	} `json:"MessageAttributes"`
+}
+
 // MessageBrokerSubscriber ...
 type MessageBrokerSubscriber struct {
-	sqsSvc              *sqs.SQS
-	snsSvc              *sns.SNS
-	handler             func(interface{}) error
+	sessionSQS          *session.Session
+	sessionSNS          *session.Session
+	handler             func(*Message) error
 	subscriberID        string
 	topicID             string
 	handleType          reflect.Type
 	maxRetries          int
 	producer            *MessageBrokerProducer
 	maxRetriesAttribute string
+	claimCheck          *ClaimCheckStore
+	resolver            *Resolver
+	client              SQSClient
+	queueURL            string
+	concurrency         int
+	waitTime            time.Duration
+	maxMessages         int
+	visibilityTimeout   time.Duration
 }
 
 // MessageBrokerSubscriberOption ...
@@ -34,30 +90,57 @@ type MessageBrokerSubscriberOption func(*MessageBrokerSubscriber)
 func NewMessageBrokerSubscriber(opts ...MessageBrokerSubscriberOption) *MessageBrokerSubscriber {
 	subscriber := new(MessageBrokerSubscriber)
 	subscriber.maxRetries = 5
+	subscriber.concurrency = 1
+	subscriber.waitTime = sqsMaxWaitTime
+	subscriber.maxMessages = sqsBatchSize
+	subscriber.visibilityTimeout = defaultVisibilityTimeout
 
 	for _, opt := range opts {
 		opt(subscriber)
 	}
 
+	if subscriber.resolver == nil && subscriber.sessionSNS != nil && subscriber.sessionSQS != nil {
+		subscriber.resolver = NewResolver(subscriber.sessionSNS, subscriber.sessionSQS)
+	}
+
 	return subscriber
 }
 
 // WithSessionSQS ...
 func WithSessionSQS(sessionSQS *session.Session) MessageBrokerSubscriberOption {
 	return func(s *MessageBrokerSubscriber) {
-		s.sqsSvc = sqs.New(sessionSQS)
+		s.sessionSQS = sessionSQS
+		s.client = sqs.New(sessionSQS)
 	}
 }
 
 // WithSessionSNS ...
 func WithSessionSNS(sessionSNS *session.Session) MessageBrokerSubscriberOption {
 	return func(s *MessageBrokerSubscriber) {
-		s.snsSvc = sns.New(sessionSNS)
+		s.sessionSNS = sessionSNS
+	}
+}
+
+// WithSubscriberResolver resolves the queues and topic with resolver, required
+// unless both WithSessionSQS and WithSessionSNS are given
+func WithSubscriberResolver(resolver *Resolver) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		s.resolver = resolver
 	}
 }
 
 // WithHandler ...
 func WithHandler(h func(interface{}) error) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		s.handler = func(m *Message) error {
+			return h(m.Body)
+		}
+	}
+}
+
+// WithMessageHandler works as WithHandler, but the handler
+// also receives the id and attributes of the message
+func WithMessageHandler(h func(*Message) error) MessageBrokerSubscriberOption {
 	return func(s *MessageBrokerSubscriber) {
 		s.handler = h
 	}
@@ -84,138 +167,264 @@ func WithType(t reflect.Type) MessageBrokerSubscriberOption {
 	}
 }
 
-// WithMaxRetries - default 5
+// WithMaxRetries - default 5, the handler is always called at least once
 func WithMaxRetries(maxRetries int) MessageBrokerSubscriberOption {
 	return func(s *MessageBrokerSubscriber) {
 		s.maxRetries = maxRetries
 	}
 }
 
-// Run ...
-func (s *MessageBrokerSubscriber) Run() error {
-	queueURL, err := createSubscriptionIfNotExists(s.sqsSvc, s.snsSvc, s.subscriberID, s.topicID, s.maxRetries)
+// WithSubscriberClaimCheck fetches the bodies published as claim checks
+// before calling the handler
+func WithSubscriberClaimCheck(store *ClaimCheckStore) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		s.claimCheck = store
+	}
+}
 
-	if err != nil {
-		logrus.WithError(err).
-			Errorf("error starting %s", s.subscriberID)
+// WithSQSClient receives the messages with client instead
+// of the client of the WithSessionSQS session
+func WithSQSClient(client SQSClient) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		s.client = client
 	}
+}
 
-	logrus.Infof("starting consumer %s with topic %s", s.subscriberID, s.topicID)
-	return s.checkMessages(s.sqsSvc, queueURL)
+// WithQueueURL consumes the queue at url, skipping the creation of
+// the queue and its subscription, for queues managed elsewhere
+func WithQueueURL(url string) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		s.queueURL = url
+	}
 }
 
-func createSubscriptionIfNotExists(sqsSvc *sqs.SQS, snsSvc *sns.SNS, subscriberID, topicID string, maxRetries int) (*string, error) {
-	listQueueResults, err := sqsSvc.ListQueues(&sqs.ListQueuesInput{
-		QueueNamePrefix: aws.String(subscriberID),
-	})
+// WithConcurrency sets how many batches of messages are handled at the same
+// time - default 1. The messages of a batch are handled one at a time, so
+// fifo queues keep the order of each message group
+func WithConcurrency(concurrency int) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		if concurrency > 0 {
+			s.concurrency = concurrency
+		}
+	}
+}
 
-	if err != nil {
-		logrus.WithError(err).
-			Errorf("error list queues %s", subscriberID)
-		return nil, err
+// WithWaitTime sets how long receiving waits for messages to arrive,
+// up to 20 seconds - default 20 seconds
+func WithWaitTime(waitTime time.Duration) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		if waitTime >= 0 && waitTime <= sqsMaxWaitTime {
+			s.waitTime = waitTime
+		}
 	}
+}
 
-	var queueURL *string
+// WithMaxMessages sets how many messages are received at a time,
+// from 1 to 10 - default 10
+func WithMaxMessages(maxMessages int) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		if maxMessages > 0 && maxMessages <= sqsBatchSize {
+			s.maxMessages = maxMessages
+		}
+	}
+}
 
-	for _, t := range listQueueResults.QueueUrls {
-		parts := strings.Split(*t, "/")
-		if strings.Compare(parts[4], subscriberID) == 0 {
-			queueURL = t
-			break
+// WithVisibilityTimeout sets how long received messages are hidden from other
+// receivers, at least a second - default 30 seconds. It is extended for the
+// messages of a batch still being handled, so it only delays the retry of
+// failed messages and of the messages of a stopped subscriber
+func WithVisibilityTimeout(timeout time.Duration) MessageBrokerSubscriberOption {
+	return func(s *MessageBrokerSubscriber) {
+		if timeout >= time.Second {
+			s.visibilityTimeout = timeout
 		}
 	}
+}
+
+// Run consumes the messages of the queue until ctx is done, receiving and
+// handling as many batches at the same time as the concurrency. Once ctx is
+// done the messages being handled are finished and deleted, the other
+// messages received are made visible again and Run returns nil. Any worker
+// failing to receive stops the others and its error is returned
+func (s *MessageBrokerSubscriber) Run(ctx context.Context) error {
+	queueURL := s.queueURL
+
+	if s.client == nil || (queueURL == "" && s.resolver == nil) {
+		logrus.WithError(ErrSubscriberNotConfigured).
+			Errorf("error starting %s", s.subscriberID)
+		return ErrSubscriberNotConfigured
+	}
+
+	if queueURL == "" {
+		var err error
+
+		queueURL, err = s.createSubscriptionIfNotExists()
+
+		if err != nil {
+			logrus.WithError(err).
+				Errorf("error starting %s", s.subscriberID)
+			return err
+		}
+	}
+
+	logrus.Infof("starting consumer %s with topic %s and %d workers", s.subscriberID, s.topicID, s.concurrency)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, s.concurrency)
+
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := s.checkMessages(ctx, queueURL); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
 
-	if queueURL != nil {
+	logrus.Infof("stopped consumer %s with topic %s", s.subscriberID, s.topicID)
+
+	// nil when every worker stopped with ctx
+	return <-errs
+}
+
+func (s *MessageBrokerSubscriber) createSubscriptionIfNotExists() (string, error) {
+	queueName, dlqName := s.queueNames()
+
+	queueURL, found, err := s.resolver.QueueURL(queueName)
+
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("error getting queue %s", s.subscriberID)
+		return "", err
+	}
+
+	if found {
 		return queueURL, nil
 	}
 
-	resp, err := sqsSvc.CreateQueue(&sqs.CreateQueueInput{
-		QueueName: aws.String(subscriberID),
-	})
+	queueURL, err = s.resolver.CreateQueue(queueName)
 
 	if err != nil {
 		logrus.WithError(err).
-			Errorf("error creating queue %s", subscriberID)
-		return nil, err
+			Errorf("error creating queue %s", s.subscriberID)
+		return "", err
 	}
 
-	queueURL = resp.QueueUrl
-	queueARN := convertQueueURLToARN(*queueURL)
+	queueARN, err := s.resolver.QueueARN(queueURL)
 
-	respdlq, err := sqsSvc.CreateQueue(&sqs.CreateQueueInput{
-		QueueName: aws.String(fmt.Sprintf("%s_dlq", subscriberID)),
-	})
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("error getting queue arn %s", s.subscriberID)
+		return "", err
+	}
+
+	dlqURL, err := s.resolver.CreateQueue(dlqName)
 
 	if err != nil {
 		logrus.WithError(err).
-			Errorf("error creating queue dlq %s", subscriberID)
-		return nil, err
+			Errorf("error creating queue dlq %s", s.subscriberID)
+		return "", err
 	}
 
-	dlqURL := respdlq.QueueUrl
-	dlqARN := convertQueueURLToARN(*dlqURL)
+	dlqARN, err := s.resolver.QueueARN(dlqURL)
 
-	topicArn, err := createTopicIfNotExists(snsSvc, topicID)
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("error getting queue dlq arn %s", s.subscriberID)
+		return "", err
+	}
+
+	topicArn, err := s.resolver.TopicARN(s.topicID)
 
 	if err != nil {
 		logrus.WithError(err).
-			Errorf("error creating topic %s", topicID)
-		return nil, err
+			Errorf("error creating topic %s", s.topicID)
+		return "", err
 	}
 
-	_, err = snsSvc.Subscribe(&sns.SubscribeInput{
-		TopicArn: topicArn,
-		Protocol: aws.String("sqs"),
-		Endpoint: &queueARN,
-	})
+	err = s.resolver.Subscribe(topicArn, queueARN)
 
 	if err != nil {
 		logrus.WithError(err).
-			Errorf("error subscribe topic %s", topicID)
-		return nil, err
+			Errorf("error subscribe topic %s", s.topicID)
+		return "", err
 	}
 
-	policyContent := "{\"Version\": \"2012-10-17\",  \"Id\": \"" + queueARN + "/SQSDefaultPolicy\",  \"Statement\": [    {     \"Sid\": \"Sid1580665629194\",      \"Effect\": \"Allow\",      \"Principal\": {        \"AWS\": \"*\"      },      \"Action\": \"SQS:SendMessage\",      \"Resource\": \"" + queueARN + "\",      \"Condition\": {        \"ArnEquals\": {         \"aws:SourceArn\": \"" + *topicArn + "\"        }      }    }  ]}"
+	policyContent := "{\"Version\": \"2012-10-17\",  \"Id\": \"" + queueARN + "/SQSDefaultPolicy\",  \"Statement\": [    {     \"Sid\": \"Sid1580665629194\",      \"Effect\": \"Allow\",      \"Principal\": {        \"AWS\": \"*\"      },      \"Action\": \"SQS:SendMessage\",      \"Resource\": \"" + queueARN + "\",      \"Condition\": {        \"ArnEquals\": {         \"aws:SourceArn\": \"" + topicArn + "\"        }      }    }  ]}"
 
 	policy := map[string]string{
 		"deadLetterTargetArn": dlqARN,
-		"maxReceiveCount":     strconv.Itoa(maxRetries),
+		"maxReceiveCount":     strconv.Itoa(s.maxRetries),
 	}
 
 	redrivePolicyContent, err := json.Marshal(policy)
 
 	if err != nil {
 		logrus.WithError(err).
-			Errorf("error marshal redrive policy %s", subscriberID)
-		return nil, err
+			Errorf("error marshal redrive policy %s", s.subscriberID)
+		return "", err
 	}
 
 	setQueueAttrInput := sqs.SetQueueAttributesInput{
-		QueueUrl: queueURL,
+		QueueUrl: aws.String(queueURL),
 		Attributes: map[string]*string{
 			sqs.QueueAttributeNamePolicy:        aws.String(policyContent),
 			sqs.QueueAttributeNameRedrivePolicy: aws.String(string(redrivePolicyContent)),
 		},
 	}
 
-	_, err = sqsSvc.SetQueueAttributes(&setQueueAttrInput)
+	_, err = s.client.SetQueueAttributesWithContext(context.Background(), &setQueueAttrInput)
 
 	if err != nil {
 		logrus.WithError(err).
-			Errorf("error set attributes policy queue %s", subscriberID)
-		return nil, err
+			Errorf("error set attributes policy queue %s", s.subscriberID)
+		return "", err
 	}
 
 	return queueURL, nil
 }
 
-func (s *MessageBrokerSubscriber) checkMessages(sqsSvc *sqs.SQS, queueURL *string) error {
+// queueNames returns the names of the queue and dead letter queue, fifo topics
+// only deliver to fifo queues, which names must end with the fifo suffix
+func (s *MessageBrokerSubscriber) queueNames() (string, string) {
+	if !IsFIFO(s.topicID) {
+		return s.subscriberID, fmt.Sprintf("%s_dlq", s.subscriberID)
+	}
+
+	name := strings.TrimSuffix(s.subscriberID, fifoSuffix)
+
+	return name + fifoSuffix, fmt.Sprintf("%s_dlq%s", name, fifoSuffix)
+}
+
+// checkMessages receives and handles batches of messages until ctx is done
+func (s *MessageBrokerSubscriber) checkMessages(ctx context.Context, queueURL string) error {
 	for {
-		retrieveMessageRequest := sqs.ReceiveMessageInput{
-			QueueUrl: queueURL,
-		}
+		output, err := s.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(int64(s.maxMessages)),
+			WaitTimeSeconds:     aws.Int64(int64(s.waitTime / time.Second)),
+			VisibilityTimeout:   aws.Int64(int64(s.visibilityTimeout / time.Second)),
+			AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameMessageGroupId)},
+		})
+
+		if ctx.Err() != nil {
+			// messages received as ctx was done are made visible again
+			if err == nil {
+				s.changeVisibility(queueURL, output.Messages, 0)
+			}
 
-		retrieveMessageResponse, err := sqsSvc.ReceiveMessage(&retrieveMessageRequest)
+			return nil
+		}
 
 		if err != nil {
 			logrus.WithError(err).
@@ -223,63 +432,254 @@ func (s *MessageBrokerSubscriber) checkMessages(sqsSvc *sqs.SQS, queueURL *strin
 			return err
 		}
 
-		if len(retrieveMessageResponse.Messages) > 0 {
+		s.handleBatch(ctx, queueURL, output.Messages)
+	}
+}
 
-			processedReceiptHandles := make([]*sqs.DeleteMessageBatchRequestEntry, len(retrieveMessageResponse.Messages))
+// handleBatch handles the messages in order and deletes the handled ones,
+// extending the visibility of both the messages left and the handled ones
+// while it takes, so no message is received again before the batch is done.
+// Once a message fails the following messages of its group are left for their
+// retry, so the group keeps its order. Once ctx is done the messages left are
+// made visible
+func (s *MessageBrokerSubscriber) handleBatch(ctx context.Context, queueURL string, messages []*sqs.Message) {
+	if len(messages) == 0 {
+		return
+	}
 
-			for i, mess := range retrieveMessageResponse.Messages {
-				byt := []byte(*mess.Body)
-				body := reflect.New(reflect.TypeOf(map[string]interface{}{})).Interface()
-				json.Unmarshal(byt, body)
+	pending := newPendingMessages(messages)
 
-				value := body.(*map[string]interface{})
-				result := (*value)["Message"]
-				messageStr := fmt.Sprintf("%v", result)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
 
-				bytMessage := []byte(messageStr)
-				bodyMessage := reflect.New(s.handleType).Interface()
-				err := json.Unmarshal(bytMessage, bodyMessage)
+	go func() {
+		defer close(stopped)
+		s.extendVisibility(queueURL, pending, stop)
+	}()
 
-				if err != nil {
-					logrus.WithError(err).WithField("content", mess.String()).
-						Errorf("cannot unmarshal message %s - sending to dlq", *mess.MessageId)
+	var handled []*sqs.Message
+	failedGroups := map[string]bool{}
 
-					processedReceiptHandles[i] = &sqs.DeleteMessageBatchRequestEntry{
-						Id:            mess.MessageId,
-						ReceiptHandle: mess.ReceiptHandle,
-					}
-				} else {
-					err = s.handler(bodyMessage)
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			break
+		}
 
-					if err == nil {
-						processedReceiptHandles[i] = &sqs.DeleteMessageBatchRequestEntry{
-							Id:            mess.MessageId,
-							ReceiptHandle: mess.ReceiptHandle,
-						}
-					}
-				}
+		group := aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
 
-			}
+		if group != "" && failedGroups[group] {
+			pending.remove(message)
+			continue
+		}
 
-			deleteMessageRequest := sqs.DeleteMessageBatchInput{
-				QueueUrl: queueURL,
-				Entries:  processedReceiptHandles,
-			}
+		if s.handle(message) {
+			// handled messages stay hidden until deleted with the batch
+			handled = append(handled, message)
+			continue
+		}
+
+		if group != "" {
+			failedGroups[group] = true
+		}
+
+		pending.remove(message)
+	}
+
+	// the last extension leaves at least half of the timeout to delete them
+	close(stop)
+	<-stopped
+
+	s.deleteMessages(queueURL, handled)
+
+	for _, message := range handled {
+		pending.remove(message)
+	}
 
-			sqsSvc.DeleteMessageBatch(&deleteMessageRequest)
+	s.changeVisibility(queueURL, pending.list(), 0)
+}
+
+// handle returns whether the message was handled, messages failing are left
+// in the queue to be retried once visible again, until they are moved to the
+// dead letter queue, as well as messages that cannot be parsed
+func (s *MessageBrokerSubscriber) handle(message *sqs.Message) bool {
+	notification := new(snsNotification)
+
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), notification); err != nil {
+		logrus.WithError(err).WithField("content", message.String()).
+			Errorf("cannot unmarshal notification %s - leaving it for the dlq", aws.StringValue(message.MessageId))
+		return false
+	}
+
+	body := []byte(notification.Message)
+
+	if s.claimCheck != nil {
+		var err error
 
+		body, err = s.claimCheck.Resolve(body)
+		if err != nil {
+			logrus.WithError(err).
+				Errorf("cannot fetch claim check body of message %s", aws.StringValue(message.MessageId))
+			return false
 		}
 	}
 
+	attributes := make(map[string]string, len(notification.MessageAttributes))
+	for name, attribute := range notification.MessageAttributes {
+		attributes[name] = attribute.Value
+	}
+
+	received, err := decodeMessage(body, attributes, s.handleType)
+
+	if err != nil {
+		logrus.WithError(err).WithField("content", message.String()).
+			Errorf("cannot unmarshal message %s - leaving it for the dlq", aws.StringValue(message.MessageId))
+		return false
+	}
+
+	received.ID = aws.StringValue(message.MessageId)
+
+	if err := s.handler(received); err != nil {
+		logrus.WithError(err).
+			Errorf("error handling message %s - leaving it to be retried", aws.StringValue(message.MessageId))
+		return false
+	}
+
+	return true
 }
 
-func convertQueueURLToARN(inputURL string) string {
-	var queueARN string
-	if strings.Contains(inputURL, "localhost") {
-		queueARN = strings.Replace(strings.Replace(strings.Replace(inputURL, "http://", "arn:aws:sqs:", -1), "localhost:4566", "us-west-2", -1), "/", ":", -1)
-	} else {
-		queueARN = strings.Replace(strings.Replace(strings.Replace(inputURL, "https://sqs.", "arn:aws:sqs:", -1), ".amazonaws.com/", ":", -1), "/", ":", -1)
+// extendVisibility keeps the pending messages hidden until stop is closed,
+// extending their visibility timeout once half of it has passed
+func (s *MessageBrokerSubscriber) extendVisibility(queueURL string, pending *pendingMessages, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.visibilityTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.changeVisibility(queueURL, pending.list(), s.visibilityTimeout)
+		}
 	}
+}
 
-	return queueARN
+// deleteMessages does not use the ctx of Run, so the handled
+// messages are always deleted when the subscriber stops
+func (s *MessageBrokerSubscriber) deleteMessages(queueURL string, messages []*sqs.Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(messages))
+	for i, message := range messages {
+		entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: message.ReceiptHandle,
+		}
+	}
+
+	output, err := s.client.DeleteMessageBatchWithContext(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries:  entries,
+	})
+
+	// messages not deleted are received again, so they must be handled idempotently
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("error deleting %d messages of %s", len(messages), s.subscriberID)
+		return
+	}
+
+	for _, failed := range output.Failed {
+		logrus.WithField("code", aws.StringValue(failed.Code)).
+			Errorf("error deleting message of %s: %s", s.subscriberID, aws.StringValue(failed.Message))
+	}
+}
+
+func (s *MessageBrokerSubscriber) changeVisibility(queueURL string, messages []*sqs.Message, timeout time.Duration) {
+	if len(messages) == 0 {
+		return
+	}
+
+	entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, len(messages))
+	for i, message := range messages {
+		entries[i] = &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(int64(timeout / time.Second)),
+		}
+	}
+
+	output, err := s.client.ChangeMessageVisibilityBatchWithContext(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries:  entries,
+	})
+
+	if err != nil {
+		logrus.WithError(err).
+			Errorf("error changing visibility of %d messages of %s", len(messages), s.subscriberID)
+		return
+	}
+
+	for _, failed := range output.Failed {
+		logrus.WithField("code", aws.StringValue(failed.Code)).
+			Errorf("error changing visibility of message of %s: %s", s.subscriberID, aws.StringValue(failed.Message))
+	}
+}
+
+// pendingMessages holds the messages of a batch not handled yet or handled
+// but not deleted, shared by the worker handling them and the one extending
+// their visibility
+type pendingMessages struct {
+	mu       sync.Mutex
+	messages []*sqs.Message
+}
+
+func newPendingMessages(messages []*sqs.Message) *pendingMessages {
+	return &pendingMessages{messages: append([]*sqs.Message(nil), messages...)}
+}
+
+func (p *pendingMessages) remove(message *sqs.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, pending := range p.messages {
+		if pending == message {
+			p.messages = append(p.messages[:i], p.messages[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *pendingMessages) list() []*sqs.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*sqs.Message(nil), p.messages...)
+}
+
+// handleWithRetries calls the handler until it succeeds, at most max retries
+// times but at least once, waiting a growing backoff between the attempts.
+// Once ctx is done no more attempts are made. Returns the number of attempts
+// and the error of the last one
+func (s *MessageBrokerSubscriber) handleWithRetries(ctx context.Context, message *Message) (int, error) {
+	backoff := retryMinBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := s.handler(message)
+		if err == nil || attempt >= s.maxRetries {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
 }
@@ -0,0 +1,700 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type subscriberTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	sqs    *standInSQS
+}
+
+func TestSubscriberTestSuite(t *testing.T) {
+	suite.Run(t, new(subscriberTestSuite))
+}
+
+func (s *subscriberTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *subscriberTestSuite) SetupTest() {
+	s.sqs = newStandInSQS()
+}
+
+func (s *subscriberTestSuite) subscriber(handler func(*core.Message) error, opts ...core.MessageBrokerSubscriberOption) core.Subscriber {
+	opts = append([]core.MessageBrokerSubscriberOption{
+		core.WithSubscriberID("inventory-read"),
+		core.WithTopicID("item-events"),
+		core.WithSQSClient(s.sqs),
+		core.WithQueueURL(subscriberQueueURL),
+		core.WithType(reflect.TypeOf(testEvent{})),
+		core.WithMessageHandler(handler),
+	}, opts...)
+
+	return core.NewMessageBrokerSubscriber(opts...)
+}
+
+// run starts the subscriber, the returned function stops it and returns its error
+func (s *subscriberTestSuite) run(subscriber core.Subscriber) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- subscriber.Run(ctx)
+	}()
+
+	return func() error {
+		cancel()
+
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			s.Fail("subscriber did not stop")
+			return nil
+		}
+	}
+}
+
+func (s *subscriberTestSuite) TestDeletesHandledMessages() {
+	s.sqs.send("1", `{"id":"1"}`, "")
+	s.sqs.send("2", `{"id":"2"}`, "")
+	s.sqs.send("3", `{"id":"3"}`, "")
+
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		if m.Body.(*testEvent).ID == "2" {
+			return errors.New("handler failed")
+		}
+
+		return nil
+	}))
+
+	s.assert.Eventually(func() bool { return len(s.sqs.deletedHandles()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	s.assert.NoError(stop())
+
+	s.assert.Equal([]string{"receipt-1", "receipt-3"}, s.sqs.deletedHandles())
+	s.assert.Empty(s.sqs.visibilityOf("receipt-2"))
+
+	input := s.sqs.lastReceive()
+	s.assert.Equal(int64(10), aws.Int64Value(input.MaxNumberOfMessages))
+	s.assert.Equal(int64(20), aws.Int64Value(input.WaitTimeSeconds))
+	s.assert.Equal(int64(30), aws.Int64Value(input.VisibilityTimeout))
+}
+
+func (s *subscriberTestSuite) TestLeavesUndecodableMessages() {
+	s.sqs.send("1", `not json`, "")
+	s.sqs.send("2", `{"id":"2"}`, "")
+
+	var handled []string
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		handled = append(handled, m.Body.(*testEvent).ID)
+		return nil
+	}))
+
+	s.assert.Eventually(func() bool { return len(s.sqs.deletedHandles()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	s.assert.NoError(stop())
+
+	s.assert.Equal([]string{"2"}, handled)
+	s.assert.Equal([]string{"receipt-2"}, s.sqs.deletedHandles())
+}
+
+func (s *subscriberTestSuite) TestStopReleasesPendingMessages() {
+	s.sqs.send("1", `{"id":"1"}`, "")
+	s.sqs.send("2", `{"id":"2"}`, "")
+	s.sqs.send("3", `{"id":"3"}`, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := s.subscriber(func(m *core.Message) error {
+		// the message being handled is finished after the signal
+		cancel()
+		return nil
+	}).Run(ctx)
+
+	s.assert.NoError(err)
+	s.assert.Equal([]string{"receipt-1"}, s.sqs.deletedHandles())
+	s.assert.Equal([]int64{0}, s.sqs.visibilityOf("receipt-2"))
+	s.assert.Equal([]int64{0}, s.sqs.visibilityOf("receipt-3"))
+}
+
+func (s *subscriberTestSuite) TestExtendsVisibility() {
+	s.sqs.send("1", `{"id":"1"}`, "")
+	s.sqs.send("2", `{"id":"2"}`, "")
+
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		time.Sleep(700 * time.Millisecond)
+		return nil
+	}, core.WithVisibilityTimeout(time.Second)))
+
+	s.assert.Eventually(func() bool { return len(s.sqs.deletedHandles()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	s.assert.NoError(stop())
+
+	// the second message waits for the first one to be handled
+	s.assert.Contains(s.sqs.visibilityOf("receipt-2"), int64(1))
+}
+
+func (s *subscriberTestSuite) TestSlowBatchIsNotReceivedAgain() {
+	s.sqs.send("1", `{"id":"1"}`, "")
+	s.sqs.send("2", `{"id":"2"}`, "")
+	s.sqs.send("3", `{"id":"3"}`, "")
+
+	var mu sync.Mutex
+	var handled []string
+
+	// the batch takes longer than the visibility timeout, while the
+	// other worker keeps receiving
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		mu.Lock()
+		handled = append(handled, m.Body.(*testEvent).ID)
+		mu.Unlock()
+
+		time.Sleep(500 * time.Millisecond)
+		return nil
+	}, core.WithVisibilityTimeout(time.Second), core.WithConcurrency(2)))
+
+	s.assert.Eventually(func() bool { return len(s.sqs.deletedHandles()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	s.assert.NoError(stop())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.assert.Equal([]string{"1", "2", "3"}, handled)
+	s.assert.Equal([]string{"receipt-1", "receipt-2", "receipt-3"}, s.sqs.deletedHandles())
+}
+
+func (s *subscriberTestSuite) TestKeepsGroupOrder() {
+	s.sqs.send("1", `{"id":"1"}`, "item-1")
+	s.sqs.send("2", `{"id":"2"}`, "item-1")
+	s.sqs.send("3", `{"id":"3"}`, "item-2")
+
+	var handled []string
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		id := m.Body.(*testEvent).ID
+		handled = append(handled, id)
+
+		if id == "1" {
+			return errors.New("handler failed")
+		}
+
+		return nil
+	}))
+
+	s.assert.Eventually(func() bool { return len(s.sqs.deletedHandles()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	s.assert.NoError(stop())
+
+	s.assert.Equal([]string{"1", "3"}, handled)
+	s.assert.Equal([]string{"receipt-3"}, s.sqs.deletedHandles())
+}
+
+func (s *subscriberTestSuite) TestConcurrency() {
+	s.sqs.send("1", `{"id":"1"}`, "")
+	s.sqs.send("2", `{"id":"2"}`, "")
+
+	var mu sync.Mutex
+	var running, maxRunning int
+
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		// waits for the other worker to handle its message
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			done := maxRunning == 2
+			mu.Unlock()
+
+			if done {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		return nil
+	}, core.WithConcurrency(2), core.WithMaxMessages(1)))
+
+	s.assert.Eventually(func() bool { return len(s.sqs.deletedHandles()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	s.assert.NoError(stop())
+
+	s.assert.Equal(2, maxRunning)
+}
+
+func (s *subscriberTestSuite) TestMapsAttributes() {
+	testCases := []struct {
+		name       string
+		attributes map[string]string
+	}{
+		{
+			name:       "without attributes",
+			attributes: map[string]string{},
+		},
+		{
+			name: "with attributes",
+			attributes: map[string]string{
+				"event_type":              "ItemCreated",
+				core.MessageKeyAttribute:  "1",
+				core.ContentTypeAttribute: core.ContentTypeJSON,
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		s.Run(tc.name, func() {
+			s.sqs = newStandInSQS()
+			s.sqs.sendWithAttributes(strconv.Itoa(i), `{"id":"1"}`, tc.attributes)
+
+			received := make(chan *core.Message, 1)
+			stop := s.run(s.subscriber(func(m *core.Message) error {
+				received <- m
+				return nil
+			}))
+
+			select {
+			case message := <-received:
+				s.assert.Equal(tc.attributes, message.Attributes)
+				s.assert.Equal("1", message.Body.(*testEvent).ID)
+			case <-time.After(5 * time.Second):
+				s.Fail("message was not handled")
+			}
+
+			s.assert.NoError(stop())
+		})
+	}
+}
+
+func (s *subscriberTestSuite) TestReceiveFailed() {
+	s.sqs.receiveErr = errors.New("receive failed")
+
+	err := s.subscriber(func(m *core.Message) error {
+		return nil
+	}, core.WithConcurrency(3)).Run(context.Background())
+
+	s.assert.Equal(s.sqs.receiveErr, err)
+}
+
+func (s *subscriberTestSuite) TestCreatesSubscription() {
+	sns := newStandInSNS(100, "item-events")
+	resolver := core.NewResolver(nil, nil, core.WithResolverSNSClient(sns), core.WithResolverSQSClient(s.sqs))
+
+	queueURL := "https://sqs.us-east-1.amazonaws.com/1/inventory-read"
+	handled := make(chan string, 1)
+
+	stop := s.run(s.subscriber(func(m *core.Message) error {
+		handled <- m.Body.(*testEvent).ID
+		return nil
+	}, core.WithQueueURL(""), core.WithSubscriberResolver(resolver), core.WithMaxRetries(3)))
+
+	s.assert.Eventually(func() bool { return s.sqs.hasAttributes(queueURL) }, 5*time.Second, 10*time.Millisecond)
+
+	s.sqs.sendTo(queueURL, "1", `{"id":"1"}`, "")
+
+	select {
+	case id := <-handled:
+		s.assert.Equal("1", id)
+	case <-time.After(5 * time.Second):
+		s.Fail("message was not handled")
+	}
+
+	s.assert.NoError(stop())
+
+	s.Require().Len(s.sqs.created, 2)
+	s.assert.Equal("inventory-read", aws.StringValue(s.sqs.created[0].QueueName))
+	s.assert.Equal("inventory-read_dlq", aws.StringValue(s.sqs.created[1].QueueName))
+	s.assert.Equal([]string{"arn:aws:sqs:us-east-1:1:inventory-read"}, sns.subscriptions[topicARNPrefix+"item-events"])
+
+	attributes := s.sqs.attributes[queueURL]
+	s.assert.Contains(aws.StringValue(attributes[sqs.QueueAttributeNamePolicy]), topicARNPrefix+"item-events")
+	s.assert.JSONEq(
+		`{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:1:inventory-read_dlq","maxReceiveCount":"3"}`,
+		aws.StringValue(attributes[sqs.QueueAttributeNameRedrivePolicy]),
+	)
+}
+
+func (s *subscriberTestSuite) TestNotConfigured() {
+	testCases := []struct {
+		name string
+		opts []core.MessageBrokerSubscriberOption
+	}{
+		{
+			name: "without client",
+			opts: []core.MessageBrokerSubscriberOption{core.WithSQSClient(nil)},
+		},
+		{
+			name: "without queue url nor resolver",
+			opts: []core.MessageBrokerSubscriberOption{core.WithQueueURL("")},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			err := s.subscriber(func(m *core.Message) error {
+				return nil
+			}, tc.opts...).Run(context.Background())
+
+			s.assert.Equal(core.ErrSubscriberNotConfigured, err)
+		})
+	}
+}
+
+// standInSQS keeps the messages of its queues in memory. Received messages
+// are hidden until deleted or their visibility timeout ends, which happens
+// right away when changed to 0. An empty queue is received from once a
+// message shows up, the wait time of the request, counted in waitUnit, has
+// passed or ctx is done
+type standInSQS struct {
+	mu         sync.Mutex
+	sequence   int
+	urls       map[string]string
+	created    []*sqs.CreateQueueInput
+	lookups    []string
+	queues     map[string][]*sqs.Message
+	hidden     map[string]*hiddenMessage
+	deleted    []string
+	visibility map[string][]int64
+	sent       [][]*sqs.SendMessageBatchRequestEntry
+	purged     []string
+	attributes map[string]map[string]*string
+	waitUnit   time.Duration
+	receive    *sqs.ReceiveMessageInput
+	receiveErr error
+	sendErr    error
+}
+
+type hiddenMessage struct {
+	url     string
+	message *sqs.Message
+	visible time.Time
+}
+
+// subscriberQueueURL is the queue the messages given to send go to
+const subscriberQueueURL = "inventory-read"
+
+func newStandInSQS() *standInSQS {
+	return &standInSQS{
+		urls:       map[string]string{},
+		queues:     map[string][]*sqs.Message{},
+		hidden:     map[string]*hiddenMessage{},
+		visibility: map[string][]int64{},
+		attributes: map[string]map[string]*string{},
+		waitUnit:   time.Second,
+	}
+}
+
+// send adds a message delivered by sns to the queue of the subscriber
+func (q *standInSQS) send(id, body, group string) {
+	q.sendTo(subscriberQueueURL, id, body, group)
+}
+
+func (q *standInSQS) sendTo(url, id, body, group string) {
+	q.sendNotification(url, id, map[string]interface{}{"Message": body}, group)
+}
+
+// sendWithAttributes adds a message delivered by sns with its message
+// attributes to the queue of the subscriber
+func (q *standInSQS) sendWithAttributes(id, body string, attributes map[string]string) {
+	messageAttributes := make(map[string]interface{}, len(attributes))
+	for name, value := range attributes {
+		messageAttributes[name] = map[string]string{"Type": "String", "Value": value}
+	}
+
+	q.sendNotification(subscriberQueueURL, id, map[string]interface{}{
+		"Message":           body,
+		"MessageAttributes": messageAttributes,
+	}, "")
+}
+
+func (q *standInSQS) sendNotification(url, id string, fields map[string]interface{}, group string) {
+	notification, _ := json.Marshal(fields)
+
+	message := &sqs.Message{
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String("receipt-" + id),
+		Body:          aws.String(string(notification)),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameSentTimestamp: aws.String(strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)),
+		},
+	}
+
+	if group != "" {
+		message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(group)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queues[url] = append(q.queues[url], message)
+}
+
+func (q *standInSQS) GetQueueUrlWithContext(ctx aws.Context, input *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	name := aws.StringValue(input.QueueName)
+	q.lookups = append(q.lookups, name)
+
+	url, ok := q.urls[name]
+	if !ok {
+		return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil)
+	}
+
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(url)}, nil
+}
+
+func (q *standInSQS) CreateQueueWithContext(ctx aws.Context, input *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	name := aws.StringValue(input.QueueName)
+	url := "https://sqs.us-east-1.amazonaws.com/1/" + name
+
+	q.created = append(q.created, input)
+	q.urls[name] = url
+
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(url)}, nil
+}
+
+func (q *standInSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.receive = input
+
+	if q.receiveErr != nil {
+		return nil, q.receiveErr
+	}
+
+	url := aws.StringValue(input.QueueUrl)
+	waited := time.Now().Add(time.Duration(aws.Int64Value(input.WaitTimeSeconds)) * q.waitUnit)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		q.showExpired()
+
+		if len(q.queues[url]) > 0 {
+			break
+		}
+
+		if time.Now().After(waited) {
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		q.mu.Lock()
+	}
+
+	count := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if count > len(q.queues[url]) {
+		count = len(q.queues[url])
+	}
+
+	messages := q.queues[url][:count]
+	q.queues[url] = q.queues[url][count:]
+
+	visible := time.Now().Add(time.Duration(aws.Int64Value(input.VisibilityTimeout)) * time.Second)
+
+	for _, message := range messages {
+		q.hidden[aws.StringValue(message.ReceiptHandle)] = &hiddenMessage{url: url, message: message, visible: visible}
+	}
+
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+// showExpired puts the messages which visibility timeout ended back in their queue
+func (q *standInSQS) showExpired() {
+	now := time.Now()
+
+	for handle, hidden := range q.hidden {
+		if !hidden.visible.After(now) {
+			q.queues[hidden.url] = append(q.queues[hidden.url], hidden.message)
+			delete(q.hidden, handle)
+		}
+	}
+}
+
+func (q *standInSQS) DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	output := new(sqs.DeleteMessageBatchOutput)
+
+	for _, entry := range input.Entries {
+		handle := aws.StringValue(entry.ReceiptHandle)
+
+		q.deleted = append(q.deleted, handle)
+		delete(q.hidden, handle)
+
+		output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+
+	return output, nil
+}
+
+func (q *standInSQS) ChangeMessageVisibilityBatchWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityBatchInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, entry := range input.Entries {
+		handle := aws.StringValue(entry.ReceiptHandle)
+		timeout := aws.Int64Value(entry.VisibilityTimeout)
+
+		q.visibility[handle] = append(q.visibility[handle], timeout)
+
+		if hidden, ok := q.hidden[handle]; ok {
+			hidden.visible = time.Now().Add(time.Duration(timeout) * time.Second)
+		}
+	}
+
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func (q *standInSQS) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.sendErr != nil {
+		return nil, q.sendErr
+	}
+
+	url := aws.StringValue(input.QueueUrl)
+	output := new(sqs.SendMessageBatchOutput)
+
+	q.sent = append(q.sent, input.Entries)
+
+	for _, entry := range input.Entries {
+		q.sequence++
+		id := "sent-" + strconv.Itoa(q.sequence)
+
+		message := &sqs.Message{
+			MessageId:         aws.String(id),
+			ReceiptHandle:     aws.String("receipt-" + id),
+			Body:              entry.MessageBody,
+			MessageAttributes: entry.MessageAttributes,
+		}
+
+		if entry.MessageGroupId != nil {
+			message.Attributes = map[string]*string{
+				sqs.MessageSystemAttributeNameMessageGroupId: entry.MessageGroupId,
+			}
+		}
+
+		q.queues[url] = append(q.queues[url], message)
+		output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String(id)})
+	}
+
+	return output, nil
+}
+
+func (q *standInSQS) SetQueueAttributesWithContext(ctx aws.Context, input *sqs.SetQueueAttributesInput, opts ...request.Option) (*sqs.SetQueueAttributesOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.attributes[aws.StringValue(input.QueueUrl)] = input.Attributes
+
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func (q *standInSQS) PurgeQueueWithContext(ctx aws.Context, input *sqs.PurgeQueueInput, opts ...request.Option) (*sqs.PurgeQueueOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	url := aws.StringValue(input.QueueUrl)
+
+	q.purged = append(q.purged, url)
+	delete(q.queues, url)
+
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+func (q *standInSQS) GetQueueAttributesWithContext(ctx aws.Context, input *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	url := aws.StringValue(input.QueueUrl)
+	count := strconv.Itoa(len(q.queues[url]))
+
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]*string{
+			sqs.QueueAttributeNameApproximateNumberOfMessages: aws.String(count),
+			sqs.QueueAttributeNameQueueArn:                    aws.String("arn:aws:sqs:us-east-1:1:" + url[strings.LastIndex(url, "/")+1:]),
+		},
+	}, nil
+}
+
+func (q *standInSQS) hasAttributes(url string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.attributes[url]
+	return ok
+}
+
+func (q *standInSQS) deletedHandles() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]string(nil), q.deleted...)
+}
+
+func (q *standInSQS) visibilityOf(handle string) []int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]int64(nil), q.visibility[handle]...)
+}
+
+func (q *standInSQS) lastReceive() *sqs.ReceiveMessageInput {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.receive
+}
+
+// bodies returns the bodies of the visible messages of the queue
+func (q *standInSQS) bodies(url string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.showExpired()
+
+	var bodies []string
+	for _, message := range q.queues[url] {
+		bodies = append(bodies, aws.StringValue(message.Body))
+	}
+
+	return bodies
+}
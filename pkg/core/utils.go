@@ -28,9 +28,11 @@ func NewSession(region, endpoint, path, profile string, fake bool) *session.Sess
 	var s *session.Session
 
 	if fake {
+		// local stand-ins only serve buckets by path
 		s = session.Must(session.NewSession(&aws.Config{
-			Region:   aws.String(region),
-			Endpoint: aws.String(endpoint),
+			Region:           aws.String(region),
+			Endpoint:         aws.String(endpoint),
+			S3ForcePathStyle: aws.Bool(true),
 		}))
 	} else {
 		s = session.Must(session.NewSession(&aws.Config{
@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"context"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/sirupsen/logrus"
+)
+
+// TradeCancelledEvent is published by the trade service when a trade is
+// cancelled or rejected, the locks held by the trade are then released
+type TradeCancelledEvent struct {
+	TradeID string `json:"trade_id"`
+}
+
+// UserDeletedEvent is published by the user service when an account is
+// deleted, the items of the user are then removed
+type UserDeletedEvent struct {
+	UserID string `json:"user_id"`
+}
+
+// NewTradeCancelledHandler returns the handler of the messages of a topic
+// receiving TradeCancelledEvent, releasing the locks for the given reason.
+// Failed messages are retried by the subscriber, releasing is idempotent
+func NewTradeCancelledHandler(service Service, reason ItemUnlockReason) func(*core.Message) error {
+	return func(message *core.Message) error {
+		event := message.Body.(*TradeCancelledEvent)
+
+		fields := logrus.Fields{
+			"message_id": message.ID,
+			"trade_id":   event.TradeID,
+			"reason":     reason,
+		}
+
+		err := service.ReleaseTradeLocks(context.Background(), &ReleaseTradeLocksRequest{
+			TradeID: event.TradeID,
+			Reason:  reason,
+		})
+
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while handling trade cancelled message")
+			return err
+		}
+
+		return nil
+	}
+}
+
+// NewUserDeletedHandler returns the handler of the messages of a topic
+// receiving UserDeletedEvent, removing the items of the user as told by
+// the policy. Failed messages are retried by the subscriber, removing is
+// idempotent
+func NewUserDeletedHandler(service Service, policy DeletionPolicy) func(*core.Message) error {
+	return func(message *core.Message) error {
+		event := message.Body.(*UserDeletedEvent)
+
+		fields := logrus.Fields{
+			"message_id": message.ID,
+			"user_id":    event.UserID,
+			"policy":     policy,
+		}
+
+		err := service.DeleteOwnerItems(context.Background(), &DeleteOwnerItemsRequest{
+			OwnerID: event.UserID,
+			Policy:  policy,
+		})
+
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while handling user deleted message")
+			return err
+		}
+
+		return nil
+	}
+}
@@ -34,6 +34,7 @@ func (c *Controller) RegisterRoutes(r *gin.RouterGroup) {
 		inventory.POST("", c.post)
 		inventory.PUT("", c.put)
 		inventory.DELETE("", c.delete)
+		inventory.POST("/unlock", c.unlock)
 	}
 }
 
@@ -90,3 +91,22 @@ func (c *Controller) delete(ctx *gin.Context) {
 
 	ctx.Status(http.StatusNoContent)
 }
+
+func (c *Controller) unlock(ctx *gin.Context) {
+	req := new(UnlockItemsRequest)
+	userID := ctx.GetString("user_id")
+
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		core.HandleRestError(ctx, core.ErrMalformedJSON)
+		return
+	}
+
+	req.OwnerID = userID
+
+	if err := c.service.UnlockItems(ctx, req); err != nil {
+		core.HandleRestError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
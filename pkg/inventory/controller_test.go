@@ -0,0 +1,118 @@
+package inventory_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory/mock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const controllerTestSecret = "secret"
+
+type controllerTestSuite struct {
+	suite.Suite
+	assert     *assert.Assertions
+	repository *mock.RepositoryMock
+	router     *gin.Engine
+}
+
+func TestControllerTestSuite(t *testing.T) {
+	suite.Run(t, new(controllerTestSuite))
+}
+
+func (s *controllerTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+	gin.SetMode(gin.TestMode)
+}
+
+func (s *controllerTestSuite) SetupTest() {
+	s.repository = mock.NewRepository().(*mock.RepositoryMock)
+
+	controller := inventory.NewController(
+		new(core.Settings),
+		core.NewAuthenticate(controllerTestSecret),
+		inventory.NewService(s.repository),
+	)
+
+	s.router = gin.New()
+	controller.RegisterRoutes(s.router.Group(""))
+}
+
+func (s *controllerTestSuite) request(method, path, userID string, body interface{}) *httptest.ResponseRecorder {
+	payload, err := json.Marshal(body)
+	s.Require().NoError(err)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": userID}).
+		SignedString([]byte(controllerTestSecret))
+	s.Require().NoError(err)
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res := httptest.NewRecorder()
+	s.router.ServeHTTP(res, req)
+
+	return res
+}
+
+// updateRequest updates items to the version published in their last event
+func (s *controllerTestSuite) updateRequest(items []*inventory.Item) *inventory.UpdateItemsRequest {
+	req := new(inventory.UpdateItemsRequest)
+
+	for _, item := range items {
+		events := item.Events()
+		version := events[len(events)-1].(*inventory.ItemCreatedEvent).Version
+
+		req.Items = append(req.Items, &inventory.UpdateItemModel{
+			ID:       item.ID,
+			Name:     string(item.Name),
+			Quantity: 10,
+			Version:  &version,
+		})
+	}
+
+	return req
+}
+
+func (s *controllerTestSuite) TestPut() {
+	userID := uuid.NewString()
+	items := createItems(2, userID)
+	req := s.updateRequest(items)
+
+	s.repository.On("Get", anyStrings).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	res := s.request(http.MethodPut, "/inventory-write", userID, req)
+
+	s.assert.Equal(http.StatusNoContent, res.Code)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+}
+
+func (s *controllerTestSuite) TestPutStaleVersion() {
+	userID := uuid.NewString()
+	items := createItems(2, userID)
+	req := s.updateRequest(items)
+
+	// the item was changed after the client read its version
+	items[1].Version++
+
+	s.repository.On("Get", anyStrings).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	res := s.request(http.MethodPut, "/inventory-write", userID, req)
+
+	s.assert.Equal(http.StatusConflict, res.Code)
+	s.assert.JSONEq(`{"key":"version-conflict"}`, res.Body.String())
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 0)
+}
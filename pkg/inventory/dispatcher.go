@@ -0,0 +1,549 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EventTypeAttribute message attribute carrying the event type,
+	// used by subscription filter policies
+	EventTypeAttribute = "event_type"
+
+	// CorrelationIDAttribute message attribute carrying the correlation id
+	// of the request that recorded the event, when there is one
+	CorrelationIDAttribute = "correlation_id"
+
+	// ReplayAttribute message attribute marking snapshots published again by
+	// Republish, it holds the id of the replay they were published by
+	ReplayAttribute = "replay"
+
+	defaultDispatchLease = time.Minute
+)
+
+// Dispatcher publishes the events stored in the outbox
+type Dispatcher struct {
+	id         string
+	repository Repository
+	producer   core.Publisher
+	events     *core.Events
+	lease      time.Duration
+	perItem    bool
+}
+
+// DispatcherOption ...
+type DispatcherOption func(*Dispatcher)
+
+// NewDispatcher ...
+func NewDispatcher(repository Repository, producer core.Publisher, events *core.Events, opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		id:         uuid.NewString(),
+		repository: repository,
+		producer:   producer,
+		events:     events,
+		lease:      defaultDispatchLease,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// WithDispatchLease sets for how long claimed messages are reserved to the
+// dispatcher, it must be longer than publishing a whole batch takes
+func WithDispatchLease(lease time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		if lease > 0 {
+			d.lease = lease
+		}
+	}
+}
+
+// WithSnapshotPerItem publishes the state of each item in its own snapshot,
+// keyed by the item id, for brokers that keep the order of messages by key
+func WithSnapshotPerItem() DispatcherOption {
+	return func(d *Dispatcher) {
+		d.perItem = true
+	}
+}
+
+// Dispatch claims up to limit pending outbox messages, publishes them in order
+// and returns how many were sent. Messages are only marked as sent after being
+// published, the ones left are released on failure and their lease expires if
+// the worker dies, so another worker publishes them again
+func (d *Dispatcher) Dispatch(ctx context.Context, limit int) (int, error) {
+	fields := logrus.Fields{"dispatcher_id": d.id}
+
+	messages, err := d.repository.ClaimOutbox(ctx, d.id, limit, d.lease)
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while claiming outbox messages")
+		return 0, err
+	}
+
+	logrus.WithFields(fields).Infof("%d new outbox messages to publish", len(messages))
+
+	sent := map[int64]bool{}
+
+	// groups are published one after the other, so a deletion
+	// never reaches consumers before a previous change of the same item
+	for _, group := range SplitOutboxByEventType(messages) {
+		if err := d.dispatch(ctx, group, sent); err != nil {
+			d.release(ctx, unsentOutbox(messages, sent))
+			return len(sent), err
+		}
+	}
+
+	return len(sent), nil
+}
+
+func (d *Dispatcher) release(ctx context.Context, messages []*OutboxMessage) {
+	fields := logrus.Fields{"dispatcher_id": d.id, "messages": len(messages)}
+
+	if err := d.repository.ReleaseOutbox(ctx, d.id, OutboxIDs(messages)); err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while releasing outbox messages, waiting for the lease to expire")
+		return
+	}
+
+	logrus.WithFields(fields).Info("released outbox messages")
+}
+
+// outboxChunk holds messages published together with the
+// snapshot of their items, which fits in a single message
+type outboxChunk struct {
+	messages  []*OutboxMessage
+	snapshot  interface{}
+	eventType EventType
+	topic     string
+	// key is the id of the item when the snapshot holds a single one
+	key string
+}
+
+// dispatch publishes messages chunk by chunk, adding the ids of
+// every chunk marked as sent to sent
+func (d *Dispatcher) dispatch(ctx context.Context, messages []*OutboxMessage, sent map[int64]bool) error {
+	eventType := messages[0].EventType
+	fields := logrus.Fields{"event_type": eventType, "messages": len(messages)}
+
+	chunks, err := d.chunk(ctx, messages)
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while splitting snapshot in chunks")
+		return err
+	}
+
+	for i, chunk := range chunks {
+		fields["chunk"] = i
+		fields["chunk_messages"] = len(chunk.messages)
+
+		for _, message := range chunk.messages {
+			attributes := map[string]string{
+				EventTypeAttribute:       string(message.EventType),
+				core.MessageKeyAttribute: message.AggregateID,
+			}
+
+			if message.CorrelationID != "" {
+				attributes[CorrelationIDAttribute] = message.CorrelationID
+			}
+
+			// the outbox id stays the same when the message is published again
+			event, err := NewCloudEvent(
+				strconv.FormatInt(message.ID, 10), message.EventType,
+				message.AggregateID, message.CreatedAt, json.RawMessage(message.Payload),
+			)
+
+			if err != nil {
+				logrus.WithError(err).WithFields(fields).Error("error while wrapping event")
+				return err
+			}
+
+			if _, err := d.producer.PublishWihAttribrutes(d.events.ItemEvents, event, attributes); err != nil {
+				logrus.WithError(err).WithFields(fields).Error("error while dispatching event")
+				return err
+			}
+		}
+
+		// keeps feeding the items-updated and items-deleted topics consumed
+		// by the read side, which only cares about the state of the items
+		if chunk.snapshot != nil {
+			if err := d.publishSnapshot(chunk, nil); err != nil {
+				logrus.WithError(err).WithFields(fields).Error("error while dispatching snapshot")
+				return err
+			}
+		}
+
+		ids := OutboxIDs(chunk.messages)
+
+		if err := d.repository.MarkOutboxSent(ctx, ids); err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while marking outbox messages as sent")
+			return err
+		}
+
+		for _, id := range ids {
+			sent[id] = true
+		}
+
+		logrus.WithFields(fields).Info("dispatched events")
+	}
+
+	return nil
+}
+
+// publishSnapshot publishes the snapshot of chunk with the given
+// attributes, keyed by its item when it holds a single one
+func (d *Dispatcher) publishSnapshot(chunk *outboxChunk, attributes map[string]string) error {
+	snapshot, err := NewCloudEvent(snapshotID(chunk.messages), chunk.eventType, chunk.key, time.Now(), chunk.snapshot)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.producer.PublishWihAttribrutes(chunk.topic, snapshot, snapshotAttributes(attributes, chunk.key))
+
+	return err
+}
+
+// snapshotID returns the id of the snapshot of messages, a name based uuid
+// derived from their outbox ids, so a snapshot published again after a
+// failure keeps its id and is deduplicated by fifo topics. Snapshots without
+// messages get a random id
+func snapshotID(messages []*OutboxMessage) string {
+	if len(messages) == 0 {
+		return uuid.NewString()
+	}
+
+	var name []byte
+	for _, id := range OutboxIDs(messages) {
+		name = strconv.AppendInt(append(name, ','), id, 10)
+	}
+
+	return uuid.NewSHA1(uuid.Nil, name).String()
+}
+
+// snapshotAttributes returns attributes with the key of the snapshot,
+// when it holds a single item
+func snapshotAttributes(attributes map[string]string, key string) map[string]string {
+	if key == "" {
+		return attributes
+	}
+
+	keyed := map[string]string{core.MessageKeyAttribute: key}
+	for name, value := range attributes {
+		keyed[name] = value
+	}
+
+	return keyed
+}
+
+// Republish publishes the current state of the items matching filter to the
+// items-updated topic again, reading batchSize items at a time, and returns
+// how many were published. Snapshots carry the ReplayAttribute and nothing is
+// written to the items or the outbox, consumers rebuilding their state rely on
+// the sequence of the items to skip what they have already applied
+func (d *Dispatcher) Republish(ctx context.Context, filter *ItemFilter, batchSize int) (int, error) {
+	replayID := uuid.NewString()
+	fields := logrus.Fields{"replay_id": replayID}
+	attributes := map[string]string{ReplayAttribute: replayID}
+
+	var published int
+	var after string
+
+	for {
+		items, err := d.repository.ListItems(ctx, filter, after, batchSize)
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while listing items to republish")
+			return published, err
+		}
+
+		if len(items) == 0 {
+			break
+		}
+
+		chunks, err := d.chunkUpdated(items, attributes)
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while splitting snapshot in chunks")
+			return published, err
+		}
+
+		for _, chunk := range chunks {
+			if err := d.publishSnapshot(chunk, attributes); err != nil {
+				logrus.WithError(err).WithFields(fields).Error("error while republishing snapshot")
+				return published, err
+			}
+
+			published = published + len(chunk.snapshot.(*ItemsUpdatedEvent).Items)
+		}
+
+		fields["published"] = published
+		logrus.WithFields(fields).Info("republished items")
+
+		if len(items) < batchSize {
+			break
+		}
+
+		after = items[len(items)-1].ID
+	}
+
+	return published, nil
+}
+
+// chunk splits messages of the same event type in chunks whose snapshot fits
+// in a single message, all messages of an item always go in the same chunk
+func (d *Dispatcher) chunk(ctx context.Context, messages []*OutboxMessage) ([]*outboxChunk, error) {
+	if messages[0].EventType == ItemDeletedEventType {
+		return d.chunkDeleted(messages, nil)
+	}
+
+	items, err := d.repository.Get(ctx, nil, AggregateIDs(messages))
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := d.chunkUpdated(items, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	chunked := map[string]bool{}
+
+	for _, chunk := range chunks {
+		ids := map[string]bool{}
+		for _, item := range chunk.snapshot.(*ItemsUpdatedEvent).Items {
+			ids[item.ID] = true
+			chunked[item.ID] = true
+		}
+
+		chunk.messages = filterOutbox(messages, ids)
+	}
+
+	// items deleted or anonymized since then are published by their own deletion event
+	var orphans []*OutboxMessage
+	for _, message := range messages {
+		if !chunked[message.AggregateID] {
+			orphans = append(orphans, message)
+		}
+	}
+
+	if len(orphans) > 0 {
+		chunks = append(chunks, &outboxChunk{messages: orphans})
+	}
+
+	return chunks, nil
+}
+
+// chunkUpdated splits the snapshot of items in chunks which fit in a
+// single message published with attributes, leaving their messages empty
+func (d *Dispatcher) chunkUpdated(items []*Item, attributes map[string]string) ([]*outboxChunk, error) {
+	// anonymized items are only published by their deletion event,
+	// as their snapshot would carry the same sequence
+	var snapshotItems []*Item
+	for _, item := range items {
+		if item.Status != ItemAnonymized {
+			snapshotItems = append(snapshotItems, item)
+		}
+	}
+
+	if len(snapshotItems) == 0 {
+		return nil, nil
+	}
+
+	event := ParseItemsToItemsUpdatedEvent(snapshotItems)
+
+	ids := make([]string, len(event.Items))
+	for i, item := range event.Items {
+		ids[i] = item.ID
+	}
+
+	snapshot := func(start, end int) interface{} {
+		return &ItemsUpdatedEvent{Items: event.Items[start:end]}
+	}
+
+	bounds, err := d.chunkBySize(ItemsUpdatedEventType, ids, snapshot, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]*outboxChunk, len(bounds))
+
+	for i, bound := range bounds {
+		chunks[i] = &outboxChunk{
+			snapshot:  snapshot(bound[0], bound[1]),
+			eventType: ItemsUpdatedEventType,
+			topic:     d.events.ItemsUpdated,
+			key:       chunkKey(ids, bound),
+		}
+	}
+
+	return chunks, nil
+}
+
+func (d *Dispatcher) chunkDeleted(messages []*OutboxMessage, attributes map[string]string) ([]*outboxChunk, error) {
+	event, err := ParseOutboxToItemsDeletedEvent(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := func(start, end int) interface{} {
+		return &ItemsDeletedEvent{Items: event.Items[start:end]}
+	}
+
+	// deleted items are parsed from the messages, one item per message
+	ids := AggregateIDs(messages)
+
+	bounds, err := d.chunkBySize(ItemsDeletedEventType, ids, snapshot, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]*outboxChunk, len(bounds))
+
+	for i, bound := range bounds {
+		chunks[i] = &outboxChunk{
+			messages:  messages[bound[0]:bound[1]],
+			snapshot:  snapshot(bound[0], bound[1]),
+			eventType: ItemsDeletedEventType,
+			topic:     d.events.ItemsDeleted,
+			key:       chunkKey(ids, bound),
+		}
+	}
+
+	return chunks, nil
+}
+
+// chunkKey returns the id of the item of a chunk holding a single one
+func chunkKey(ids []string, bound [2]int) string {
+	if bound[1]-bound[0] != 1 {
+		return ""
+	}
+
+	return ids[bound[0]]
+}
+
+// chunkBySize returns the start and end of the items of each snapshot, sized
+// as the producer publishes them. snapshot returns the data of the snapshot
+// holding the items from start to end, whose ids are given by ids
+func (d *Dispatcher) chunkBySize(eventType EventType, ids []string, snapshot func(start, end int) interface{}, attributes map[string]string) ([][2]int, error) {
+	if d.perItem {
+		bounds := make([][2]int, len(ids))
+		for i := range bounds {
+			bounds[i] = [2]int{i, i + 1}
+		}
+
+		return bounds, nil
+	}
+
+	// items are measured as what they add to an empty snapshot, published
+	// with the longest key, so the estimate never falls short by the key
+	var longestKey string
+	for _, id := range ids {
+		if len(id) > len(longestKey) {
+			longestKey = id
+		}
+	}
+
+	overhead, err := d.snapshotSize(eventType, longestKey, snapshot(0, 0), attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]int, len(ids))
+	for i := range ids {
+		size, err := d.snapshotSize(eventType, longestKey, snapshot(i, i+1), attributes)
+		if err != nil {
+			return nil, err
+		}
+
+		sizes[i] = size - overhead
+	}
+
+	var bounds [][2]int
+	start := 0
+
+	for _, count := range core.ChunkBySize(sizes, overhead, d.producer.MaxMessageSize()) {
+		fitted, err := d.fitChunk(eventType, ids, snapshot, attributes, [2]int{start, start + count})
+		if err != nil {
+			return nil, err
+		}
+
+		bounds = append(bounds, fitted...)
+		start = start + count
+	}
+
+	return bounds, nil
+}
+
+// fitChunk measures the snapshot of the chunk as it is published and splits
+// it in halves until every half fits, as encodings such as base64 or
+// protojson do not grow by the exact size of each item. A single item that
+// does not fit by itself is left to the claim check
+func (d *Dispatcher) fitChunk(eventType EventType, ids []string, snapshot func(start, end int) interface{}, attributes map[string]string, bound [2]int) ([][2]int, error) {
+	if bound[1]-bound[0] < 2 {
+		return [][2]int{bound}, nil
+	}
+
+	size, err := d.snapshotSize(eventType, chunkKey(ids, bound), snapshot(bound[0], bound[1]), attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= d.producer.MaxMessageSize() {
+		return [][2]int{bound}, nil
+	}
+
+	middle := bound[0] + (bound[1]-bound[0])/2
+
+	first, err := d.fitChunk(eventType, ids, snapshot, attributes, [2]int{bound[0], middle})
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := d.fitChunk(eventType, ids, snapshot, attributes, [2]int{middle, bound[1]})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(first, second...), nil
+}
+
+// snapshotSize returns the size of the snapshot wrapped in its cloud event and
+// published with attributes and key, measured with the longest time the
+// envelope can hold as snapshots are wrapped again when published
+func (d *Dispatcher) snapshotSize(eventType EventType, key string, data interface{}, attributes map[string]string) (int, error) {
+	at := time.Now().Truncate(time.Second).Add(time.Second - time.Nanosecond)
+
+	event, err := NewCloudEvent(uuid.NewString(), eventType, key, at, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.producer.MessageSize(event, snapshotAttributes(attributes, key))
+}
+
+func filterOutbox(messages []*OutboxMessage, aggregateIDs map[string]bool) []*OutboxMessage {
+	var filtered []*OutboxMessage
+
+	for _, message := range messages {
+		if aggregateIDs[message.AggregateID] {
+			filtered = append(filtered, message)
+		}
+	}
+
+	return filtered
+}
+
+func unsentOutbox(messages []*OutboxMessage, sent map[int64]bool) []*OutboxMessage {
+	var unsent []*OutboxMessage
+
+	for _, message := range messages {
+		if !sent[message.ID] {
+			unsent = append(unsent, message)
+		}
+	}
+
+	return unsent
+}
@@ -0,0 +1,531 @@
+package inventory_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory/mock"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory/proto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type dispatcherTestSuite struct {
+	suite.Suite
+	assert     *assert.Assertions
+	ctx        context.Context
+	events     *core.Events
+	repository *mock.RepositoryMock
+	broker     *core.MemoryBroker
+	dispatcher *inventory.Dispatcher
+}
+
+func TestDispatcherTestSuite(t *testing.T) {
+	suite.Run(t, new(dispatcherTestSuite))
+}
+
+func (s *dispatcherTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+	s.ctx = context.Background()
+	s.events = &core.Events{
+		ItemsUpdated: "items-updated",
+		ItemsDeleted: "items-deleted",
+		ItemEvents:   "item-events",
+	}
+}
+
+func (s *dispatcherTestSuite) SetupTest() {
+	s.repository = mock.NewRepository().(*mock.RepositoryMock)
+	s.setupBroker()
+}
+
+// setupBroker replaces the broker by one retaining the published messages
+func (s *dispatcherTestSuite) setupBroker(opts ...core.MemoryBrokerOption) {
+	s.broker = core.NewMemoryBroker(append(opts, core.WithMemoryRetention())...)
+	s.dispatcher = inventory.NewDispatcher(s.repository, s.broker, s.events)
+}
+
+func (s *dispatcherTestSuite) TestDispatch() {
+	items := createItems(2, uuid.NewString())
+	messages := createOutbox(items)
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID, items[1].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1, 2}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+	s.assert.Equal(2, sent)
+
+	published := s.broker.Messages(s.events.ItemEvents)
+	s.assert.Len(published, 2)
+
+	for i, message := range published {
+		s.assert.Equal(string(inventory.ItemCreatedEventType), message.Attributes[inventory.EventTypeAttribute])
+		s.assert.NotContains(message.Attributes, inventory.CorrelationIDAttribute)
+
+		_, envelope := core.UnwrapCloudEvent(message.Body.(json.RawMessage))
+		s.assert.Equal(inventory.EventSource, envelope.Source)
+		s.assert.Equal(inventory.EventTypePrefix+string(inventory.ItemCreatedEventType), envelope.Type)
+		s.assert.Equal(items[i].ID, envelope.Subject)
+		s.assert.Equal(strconv.FormatInt(messages[i].ID, 10), envelope.ID)
+	}
+
+	snapshots := s.broker.Messages(s.events.ItemsUpdated)
+	s.assert.Len(snapshots, 1)
+
+	data, envelope := core.UnwrapCloudEvent(snapshots[0].Body.(json.RawMessage))
+	s.assert.NotNil(envelope)
+	s.assert.Equal(inventory.EventSchema(inventory.ItemsUpdatedEventType, 1), envelope.DataSchema)
+
+	snapshot := new(inventory.ItemsUpdatedEvent)
+	s.assert.NoError(json.Unmarshal(data, snapshot))
+	s.assert.Len(snapshot.Items, 2)
+
+	s.repository.AssertNotCalled(s.T(), "ReleaseOutbox")
+}
+
+func (s *dispatcherTestSuite) TestDispatchAgainKeepsSnapshotID() {
+	items := createItems(2, uuid.NewString())
+	messages := createOutbox(items)
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil).Twice()
+	s.repository.On("ClaimOutbox", 10).Return(messages[:1], nil).Once()
+	s.repository.On("Get", anyStrings).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1, 2}).Return(nil)
+	s.repository.On("MarkOutboxSent", []int64{1}).Return(nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := s.dispatcher.Dispatch(s.ctx, 10)
+		s.assert.NoError(err)
+	}
+
+	snapshots := s.broker.Messages(s.events.ItemsUpdated)
+	s.Require().Len(snapshots, 3)
+
+	ids := make([]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		_, envelope := core.UnwrapCloudEvent(snapshot.Body.(json.RawMessage))
+		ids[i] = envelope.ID
+	}
+
+	// the same outbox messages are deduplicated by the id of their snapshot
+	s.assert.Equal(ids[0], ids[1])
+	s.assert.NotEqual(ids[0], ids[2])
+}
+
+func (s *dispatcherTestSuite) TestDispatchCorrelationID() {
+	items := createItems(1, uuid.NewString())
+	messages := createOutbox(items)
+
+	correlationID := uuid.NewString()
+	messages[0].CorrelationID = correlationID
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1}).Return(nil)
+
+	_, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+
+	published := s.broker.Messages(s.events.ItemEvents)
+	s.assert.Len(published, 1)
+	s.assert.Equal(correlationID, published[0].Attributes[inventory.CorrelationIDAttribute])
+}
+
+func (s *dispatcherTestSuite) TestDispatchProtobuf() {
+	items := createItems(2, uuid.NewString())
+	messages := createOutbox(items)
+
+	s.setupBroker(core.WithMemoryEncoding(core.EncodingProtobuf))
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID, items[1].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1, 2}).Return(nil)
+
+	_, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+
+	published := s.broker.Messages(s.events.ItemEvents)
+	s.assert.Len(published, 2)
+
+	for i, message := range published {
+		s.assert.Equal(core.ContentTypeProtobuf, message.Attributes[core.ContentTypeAttribute])
+
+		_, envelope := core.UnwrapCloudEvent(message.Body.(json.RawMessage))
+		s.assert.Equal(core.ContentTypeProtobuf, envelope.DataContentType)
+		s.assert.Empty(envelope.Data)
+
+		eventType, data, err := inventory.DecodeCloudEvent(envelope)
+		s.assert.NoError(err)
+		s.assert.Equal(inventory.ItemCreatedEventType, eventType)
+		s.assert.Equal(items[i].ID, data.(*proto.ItemUpdatedEvent).Id)
+		s.assert.Equal(int64(1), data.(*proto.ItemUpdatedEvent).Sequence)
+	}
+
+	snapshots := s.broker.Messages(s.events.ItemsUpdated)
+	s.assert.Len(snapshots, 1)
+
+	_, envelope := core.UnwrapCloudEvent(snapshots[0].Body.(json.RawMessage))
+	_, data, err := inventory.DecodeCloudEvent(envelope)
+
+	s.assert.NoError(err)
+	s.assert.Len(data.(*proto.ItemsUpdatedEvent).Items, 2)
+	s.assert.Equal(items[1].ID, data.(*proto.ItemsUpdatedEvent).Items[1].Id)
+}
+
+func (s *dispatcherTestSuite) TestDispatchProtoJSON() {
+	items := createItems(1, uuid.NewString())
+	items[0].Delete()
+	messages := createOutbox(items)
+
+	s.setupBroker(core.WithMemoryEncoding(core.EncodingProtoJSON))
+
+	s.repository.On("ClaimOutbox", 10).Return(messages[1:], nil)
+	s.repository.On("MarkOutboxSent", []int64{2}).Return(nil)
+
+	_, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+
+	published := s.broker.Messages(s.events.ItemEvents)
+	s.assert.Len(published, 1)
+	s.assert.Equal(core.ContentTypeProtoJSON, published[0].Attributes[core.ContentTypeAttribute])
+
+	_, envelope := core.UnwrapCloudEvent(published[0].Body.(json.RawMessage))
+	eventType, data, err := inventory.DecodeCloudEvent(envelope)
+
+	s.assert.NoError(err)
+	s.assert.Equal(inventory.ItemDeletedEventType, eventType)
+	s.assert.Equal(items[0].ID, data.(*proto.ItemDeletedEvent).Id)
+	s.assert.Equal(int64(2), data.(*proto.ItemDeletedEvent).Sequence)
+
+	snapshots := s.broker.Messages(s.events.ItemsDeleted)
+	s.assert.Len(snapshots, 1)
+	s.assert.Equal(core.ContentTypeProtoJSON, snapshots[0].Attributes[core.ContentTypeAttribute])
+}
+
+func (s *dispatcherTestSuite) TestDispatchChunksSnapshot() {
+	items := createItems(2, uuid.NewString())
+	messages := createOutbox(items)
+
+	// only one item fits in each snapshot, published with its key
+	var limit int
+	for _, item := range items {
+		size := s.snapshotSize(s.broker, []*inventory.Item{item}, map[string]string{core.MessageKeyAttribute: item.ID})
+		if size > limit {
+			limit = size
+		}
+	}
+
+	s.setupBroker(core.WithMemoryMaxMessageSize(limit))
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID, items[1].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1}).Return(nil)
+	s.repository.On("MarkOutboxSent", []int64{2}).Return(errors.New("mark failed"))
+	s.repository.On("ReleaseOutbox", []int64{2}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.Error(err)
+	s.assert.Equal(1, sent)
+	s.assert.Len(s.broker.Messages(s.events.ItemsUpdated), 2)
+	s.repository.AssertCalled(s.T(), "ReleaseOutbox", []int64{2})
+}
+
+func (s *dispatcherTestSuite) TestRepublishChunksProtoJSON() {
+	items := createItems(4, uuid.NewString())
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	encoding := core.WithMemoryEncoding(core.EncodingProtoJSON)
+	attributes := map[string]string{inventory.ReplayAttribute: uuid.NewString()}
+
+	// two items fill a snapshot up to the limit, attributes included
+	limit := s.snapshotSize(core.NewMemoryBroker(encoding), items[:2], attributes)
+
+	for _, tc := range []struct {
+		limit int
+		sizes []int
+	}{
+		{limit: limit - 1, sizes: []int{1, 1, 1, 1}},
+		{limit: limit + 128, sizes: []int{2, 2}},
+	} {
+		s.SetupTest()
+		s.setupBroker(encoding, core.WithMemoryMaxMessageSize(tc.limit))
+
+		filter := new(inventory.ItemFilter)
+		s.repository.On("ListItems", filter, "", 10).Return(items, nil)
+
+		published, err := s.dispatcher.Republish(s.ctx, filter, 10)
+
+		s.assert.NoError(err)
+		s.assert.Equal(len(items), published)
+
+		var sizes []int
+		for _, snapshot := range s.broker.Messages(s.events.ItemsUpdated) {
+			s.assert.Equal(core.ContentTypeProtoJSON, snapshot.Attributes[core.ContentTypeAttribute])
+
+			_, envelope := core.UnwrapCloudEvent(snapshot.Body.(json.RawMessage))
+			_, data, err := inventory.DecodeCloudEvent(envelope)
+			s.assert.NoError(err)
+
+			sizes = append(sizes, len(data.(*proto.ItemsUpdatedEvent).Items))
+		}
+
+		s.assert.Equal(tc.sizes, sizes)
+	}
+}
+
+func (s *dispatcherTestSuite) TestDispatchChunksSnapshotProtoJSON() {
+	items := createItems(3, uuid.NewString())
+	messages := createOutbox(items)
+
+	encoding := core.WithMemoryEncoding(core.EncodingProtoJSON)
+
+	// a single item per snapshot, as two go over the limit by a byte
+	limit := s.snapshotSize(core.NewMemoryBroker(encoding), items[:2], nil) - 1
+
+	s.setupBroker(encoding, core.WithMemoryMaxMessageSize(limit))
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID, items[1].ID, items[2].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1}).Return(nil)
+	s.repository.On("MarkOutboxSent", []int64{2}).Return(nil)
+	s.repository.On("MarkOutboxSent", []int64{3}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+	s.assert.Equal(3, sent)
+
+	snapshots := s.broker.Messages(s.events.ItemsUpdated)
+	s.assert.Len(snapshots, 3)
+
+	for i, snapshot := range snapshots {
+		s.assert.Equal(items[i].ID, snapshot.Attributes[core.MessageKeyAttribute])
+	}
+}
+
+// snapshotSize returns the size of the snapshot of items as published by
+// broker with attributes, with the longest time its envelope can hold
+func (s *dispatcherTestSuite) snapshotSize(broker *core.MemoryBroker, items []*inventory.Item, attributes map[string]string) int {
+	var subject string
+	if key, ok := attributes[core.MessageKeyAttribute]; ok {
+		subject = key
+	}
+
+	at := time.Now().Truncate(time.Second).Add(time.Second - time.Nanosecond)
+
+	event, err := inventory.NewCloudEvent(
+		uuid.NewString(), inventory.ItemsUpdatedEventType, subject, at,
+		inventory.ParseItemsToItemsUpdatedEvent(items),
+	)
+	s.assert.NoError(err)
+
+	size, err := broker.MessageSize(event, attributes)
+	s.assert.NoError(err)
+
+	return size
+}
+
+func (s *dispatcherTestSuite) TestDispatchSnapshotPerItem() {
+	items := createItems(2, uuid.NewString())
+	messages := createOutbox(items)
+
+	s.dispatcher = inventory.NewDispatcher(s.repository, s.broker, s.events, inventory.WithSnapshotPerItem())
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID, items[1].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1}).Return(nil)
+	s.repository.On("MarkOutboxSent", []int64{2}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+	s.assert.Equal(2, sent)
+
+	for i, message := range s.broker.Messages(s.events.ItemEvents) {
+		s.assert.Equal(items[i].ID, message.Attributes[core.MessageKeyAttribute])
+	}
+
+	snapshots := s.broker.Messages(s.events.ItemsUpdated)
+	s.assert.Len(snapshots, 2)
+
+	for i, message := range snapshots {
+		s.assert.Equal(items[i].ID, message.Attributes[core.MessageKeyAttribute])
+	}
+}
+
+func (s *dispatcherTestSuite) TestDispatchDeleted() {
+	items := createItems(2, uuid.NewString())
+	for _, item := range items {
+		item.ClearEvents()
+		item.Delete()
+	}
+
+	messages := createOutbox(items)
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("MarkOutboxSent", []int64{1, 2}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+	s.assert.Equal(2, sent)
+	s.assert.Empty(s.broker.Messages(s.events.ItemsUpdated))
+
+	snapshots := s.broker.Messages(s.events.ItemsDeleted)
+	s.assert.Len(snapshots, 1)
+
+	data, _ := core.UnwrapCloudEvent(snapshots[0].Body.(json.RawMessage))
+
+	snapshot := new(inventory.ItemsDeletedEvent)
+	s.assert.NoError(json.Unmarshal(data, snapshot))
+	s.assert.Len(snapshot.Items, 2)
+	s.assert.Equal(items[0].ID, snapshot.Items[0].ID)
+
+	s.repository.AssertNotCalled(s.T(), "Get")
+}
+
+func (s *dispatcherTestSuite) TestDispatchAnonymized() {
+	items := createItems(1, uuid.NewString())
+	s.assert.NoError(items[0].Lock(uuid.NewString(), 1, 0))
+
+	items[0].ClearEvents()
+	items[0].ReleaseLocks(inventory.ItemUnlockOwnerDeleted)
+	items[0].Anonymize()
+
+	messages := createOutbox(items)
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID}).Return(items, nil)
+	s.repository.On("MarkOutboxSent", []int64{1}).Return(nil)
+	s.repository.On("MarkOutboxSent", []int64{2}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+	s.assert.Equal(2, sent)
+	s.assert.Empty(s.broker.Messages(s.events.ItemsUpdated))
+	s.assert.Len(s.broker.Messages(s.events.ItemsDeleted), 1)
+	s.assert.Len(s.broker.Messages(s.events.ItemEvents), 2)
+}
+
+func (s *dispatcherTestSuite) TestDispatchPublishFailed() {
+	items := createItems(2, uuid.NewString())
+	messages := createOutbox(items)
+
+	s.setupBroker(core.WithMemoryMaxMessageSize(10))
+
+	s.repository.On("ClaimOutbox", 10).Return(messages, nil)
+	s.repository.On("Get", []string{items[0].ID, items[1].ID}).Return(items, nil)
+	s.repository.On("ReleaseOutbox", []int64{1, 2}).Return(nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.Equal(core.ErrMessageTooLarge, err)
+	s.assert.Equal(0, sent)
+	s.repository.AssertNotCalled(s.T(), "MarkOutboxSent")
+	s.repository.AssertCalled(s.T(), "ReleaseOutbox", []int64{1, 2})
+}
+
+func (s *dispatcherTestSuite) TestRepublish() {
+	items := createItems(3, uuid.NewString())
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	filter := &inventory.ItemFilter{OwnerID: items[0].OwnerID}
+
+	s.repository.On("ListItems", filter, "", 2).Return(items[:2], nil)
+	s.repository.On("ListItems", filter, items[1].ID, 2).Return(items[2:], nil)
+
+	published, err := s.dispatcher.Republish(s.ctx, filter, 2)
+
+	s.assert.NoError(err)
+	s.assert.Equal(3, published)
+
+	snapshots := s.broker.Messages(s.events.ItemsUpdated)
+	s.assert.Len(snapshots, 2)
+
+	replayID := snapshots[0].Attributes[inventory.ReplayAttribute]
+	s.assert.NotEmpty(replayID)
+
+	var republished []string
+	for _, snapshot := range snapshots {
+		s.assert.Equal(replayID, snapshot.Attributes[inventory.ReplayAttribute])
+
+		data, _ := core.UnwrapCloudEvent(snapshot.Body.(json.RawMessage))
+		event := new(inventory.ItemsUpdatedEvent)
+		s.assert.NoError(json.Unmarshal(data, event))
+
+		for _, item := range event.Items {
+			republished = append(republished, item.ID)
+		}
+	}
+
+	s.assert.Equal([]string{items[0].ID, items[1].ID, items[2].ID}, republished)
+	s.assert.Empty(s.broker.Messages(s.events.ItemEvents))
+
+	s.repository.AssertNotCalled(s.T(), "ClaimOutbox", 2)
+	s.repository.AssertNotCalled(s.T(), "UpdateBulk", items)
+}
+
+func (s *dispatcherTestSuite) TestRepublishListFailed() {
+	filter := new(inventory.ItemFilter)
+
+	s.repository.On("ListItems", filter, "", 10).Return(nil, errors.New("list failed"))
+
+	published, err := s.dispatcher.Republish(s.ctx, filter, 10)
+
+	s.assert.Error(err)
+	s.assert.Equal(0, published)
+	s.assert.Empty(s.broker.Messages(s.events.ItemsUpdated))
+}
+
+func (s *dispatcherTestSuite) TestDispatchNothingClaimed() {
+	s.repository.On("ClaimOutbox", 10).Return([]*inventory.OutboxMessage{}, nil)
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.NoError(err)
+	s.assert.Equal(0, sent)
+	s.repository.AssertNotCalled(s.T(), "MarkOutboxSent")
+	s.repository.AssertNotCalled(s.T(), "ReleaseOutbox")
+}
+
+func (s *dispatcherTestSuite) TestDispatchClaimFailed() {
+	s.repository.On("ClaimOutbox", 10).Return(nil, errors.New("claim failed"))
+
+	sent, err := s.dispatcher.Dispatch(s.ctx, 10)
+
+	s.assert.Error(err)
+	s.assert.Equal(0, sent)
+	s.repository.AssertNotCalled(s.T(), "ReleaseOutbox")
+}
+
+// createOutbox returns the outbox messages of the events recorded by items,
+// numbered from 1 as if they had been written to the outbox
+func createOutbox(items []*inventory.Item) []*inventory.OutboxMessage {
+	var messages []*inventory.OutboxMessage
+
+	for _, item := range items {
+		for _, event := range item.Events() {
+			message, _ := inventory.NewOutboxMessage(event)
+			message.ID = int64(len(messages) + 1)
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
@@ -15,9 +15,34 @@ const (
 	// ItemAvailable is set when an item is available to be traded
 	ItemAvailable ItemStatus = "Available"
 
-	// ItemPendingUpdateDispatch is set when an item has been updated
-	// but has not yet dispached an event
-	ItemPendingUpdateDispatch ItemStatus = "PendingUpdateDispatch"
+	// ItemAnonymized is set when the owner account was deleted and
+	// the item was kept without any of the owner data
+	ItemAnonymized ItemStatus = "Anonymized"
+)
+
+const (
+	// AnonymizedOwnerID replaces the owner of anonymized items,
+	// so they are never returned to any user
+	AnonymizedOwnerID = "anonymized"
+
+	// AnonymizedItemName replaces the name of anonymized items
+	AnonymizedItemName ItemName = "Anonymized item"
+
+	// AnonymizedItemDescription replaces the description of anonymized
+	// items, it is empty as descriptions can't be null
+	AnonymizedItemDescription ItemDescription = ""
+)
+
+// DeletionPolicy tells what happens to the items of a deleted owner
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete removes the items of the owner
+	DeletionPolicyDelete DeletionPolicy = "delete"
+
+	// DeletionPolicyAnonymize keeps the items of the owner, removing
+	// the owner, name and description
+	DeletionPolicyAnonymize DeletionPolicy = "anonymize"
 )
 
 // ItemName ...
@@ -31,8 +56,10 @@ type ItemQuantity int64
 
 // ItemLock ...
 type ItemLock struct {
-	LockedBy string
-	Quantity ItemQuantity
+	LockedBy  string
+	Quantity  ItemQuantity
+	LockedAt  time.Time
+	ExpiresAt *time.Time
 }
 
 // Item ...
@@ -44,17 +71,61 @@ type Item struct {
 	Description   *ItemDescription
 	TotalQuantity ItemQuantity
 	Locks         []*ItemLock
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	Version       int64
+	// Sequence is the number of the last event recorded by the item, it grows
+	// by one with every event, so consumers can drop the stale ones
+	Sequence  int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	events []Event
+	// persisted is set once the item is loaded from or saved to the repository
+	persisted bool
+}
+
+// ItemFilter selects items by owner, ids and last update,
+// the fields left empty match every item
+type ItemFilter struct {
+	OwnerID      string
+	IDs          []string
+	UpdatedSince *time.Time
 }
 
 // Repository ...
 type Repository interface {
+	// WithTransaction runs fn in a single transaction, every repository
+	// call made with the context given to fn joins it
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 	InsertBulk(ctx context.Context, items []*Item) error
 	UpdateBulk(ctx context.Context, items []*Item) error
-	DeleteBulk(ctx context.Context, ids []string) error
+	// DeleteBulk writes the deletion event of every item to the outbox in the
+	// same transaction, recording it for the items which did not record it
+	DeleteBulk(ctx context.Context, items []*Item) error
 	Get(ctx context.Context, userID *string, ids []string) ([]*Item, error)
-	GetByStatus(ctx context.Context, status ItemStatus) ([]*Item, error)
+	// GetForUpdate works as Get but locks the returned items until
+	// the transaction started by WithTransaction ends
+	GetForUpdate(ctx context.Context, userID *string, ids []string) ([]*Item, error)
+	// GetWithExpiredLocks returns up to limit items with locks expired until the
+	// given time, locked until the transaction started by WithTransaction ends.
+	// Items locked by other transactions are skipped
+	GetWithExpiredLocks(ctx context.Context, until time.Time, limit int) ([]*Item, error)
+	// GetByOwnerForUpdate returns every item of the owner, locked until
+	// the transaction started by WithTransaction ends
+	GetByOwnerForUpdate(ctx context.Context, ownerID string) ([]*Item, error)
+	// GetLockedByForUpdate returns the items locked by lockedBy, locked until
+	// the transaction started by WithTransaction ends
+	GetLockedByForUpdate(ctx context.Context, lockedBy string) ([]*Item, error)
+	// ListItems returns up to limit items matching filter ordered by id,
+	// starting after the id given, so every item is read page by page
+	ListItems(ctx context.Context, filter *ItemFilter, after string, limit int) ([]*Item, error)
+	// ClaimOutbox reserves up to limit pending messages for workerID until the
+	// lease ends, messages claimed by other workers are never returned
+	ClaimOutbox(ctx context.Context, workerID string, limit int, lease time.Duration) ([]*OutboxMessage, error)
+	ReleaseOutbox(ctx context.Context, workerID string, ids []int64) error
+	MarkOutboxSent(ctx context.Context, ids []int64) error
+	// ListenOutbox notifies the returned channel whenever new messages
+	// are written to the outbox, until ctx is done
+	ListenOutbox(ctx context.Context) (<-chan struct{}, error)
 }
 
 // Service ...
@@ -62,8 +133,25 @@ type Service interface {
 	CreateItems(ctx context.Context, userID, correlationID string, req *CreateItemsRequest) error
 	UpdateItems(ctx context.Context, userID, correlationID string, req *UpdateItemsRequest) error
 	LockItems(ctx context.Context, req *LockItemsRequest) error
+	UnlockItems(ctx context.Context, req *UnlockItemsRequest) error
 	TradeItems(ctx context.Context, req *TradeItemsRequest) error
 	DeleteItems(ctx context.Context, userID, correlationID string, req *DeleteItemsRequest) error
+	ReleaseExpiredLocks(ctx context.Context) error
+	ReleaseTradeLocks(ctx context.Context, req *ReleaseTradeLocksRequest) error
+	DeleteOwnerItems(ctx context.Context, req *DeleteOwnerItemsRequest) error
+}
+
+// ParseDeletionPolicy returns the policy named by value,
+// DeletionPolicyDelete when it is empty
+func ParseDeletionPolicy(value string) (DeletionPolicy, error) {
+	switch policy := DeletionPolicy(strings.ToLower(value)); policy {
+	case "":
+		return DeletionPolicyDelete, nil
+	case DeletionPolicyDelete, DeletionPolicyAnonymize:
+		return policy, nil
+	default:
+		return "", core.ErrValidationFailed
+	}
 }
 
 // NewItemName ...
@@ -121,16 +209,61 @@ func NewItem(id, ownerID, name string, description *string, quantity int64, stat
 		return nil, core.ErrValidationFailed
 	}
 
-	return &Item{
+	item := &Item{
 		ID:            id,
 		OwnerID:       ownerID,
 		Name:          itemName,
 		Status:        status,
 		Description:   NewItemDescription(description),
 		TotalQuantity: itemQuantity,
+		Version:       1,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
-	}, nil
+	}
+
+	item.record((*ItemCreatedEvent)(ParseItemToItemUpdatedEvent(item)))
+
+	return item, nil
+}
+
+// Events returns the events recorded since the item was loaded
+func (item *Item) Events() []Event {
+	return item.events
+}
+
+// ClearEvents is called once the recorded events have been persisted
+func (item *Item) ClearEvents() {
+	item.events = nil
+}
+
+// MarkPersisted is called by the repository once the item is loaded or saved,
+// the events recorded afterwards carry the version the item is updated to
+func (item *Item) MarkPersisted() {
+	item.persisted = true
+}
+
+func (item *Item) record(event Event) {
+	// new items are inserted with their version,
+	// persisted items are updated to the next one
+	if e, ok := event.(versionedEvent); ok {
+		version := item.Version
+		if item.persisted {
+			version++
+		}
+
+		e.setVersion(version)
+	}
+
+	item.Sequence++
+	event.setSequence(item.Sequence)
+
+	item.events = append(item.events, event)
+}
+
+// IsExpired reports whether the lock has an expiration
+// and it has already been reached
+func (lock *ItemLock) IsExpired(now time.Time) bool {
+	return lock.ExpiresAt != nil && !lock.ExpiresAt.After(now)
 }
 
 // GetLockedQuantity ...
@@ -166,9 +299,19 @@ func (item *Item) Update(name string, description *string, quantity int64) error
 	item.Name = itemName
 	item.Description = itemDescription
 	item.TotalQuantity = itemQuantity
-	item.Status = ItemPendingUpdateDispatch
 	item.UpdatedAt = time.Now()
 
+	item.record(ParseItemToItemUpdatedEvent(item))
+
+	return nil
+}
+
+// CheckVersion ...
+func (item *Item) CheckVersion(expected int64) error {
+	if item.Version != expected {
+		return core.ErrVersionConflict
+	}
+
 	return nil
 }
 
@@ -178,8 +321,8 @@ func (item *Item) UpdateStatus(status ItemStatus) {
 	item.UpdatedAt = time.Now()
 }
 
-// Lock ...
-func (item *Item) Lock(lockedBy string, quantity int64) error {
+// Lock locks the given quantity, a ttl of zero means the lock never expires
+func (item *Item) Lock(lockedBy string, quantity int64, ttl time.Duration) error {
 
 	itemQuantity, err := NewItemQuantity(quantity)
 	if err != nil {
@@ -192,13 +335,215 @@ func (item *Item) Lock(lockedBy string, quantity int64) error {
 		return core.ErrNotEnoughtItemsToLock
 	}
 
-	item.Locks = append(item.Locks, &ItemLock{
+	lock := &ItemLock{
 		LockedBy: lockedBy,
 		Quantity: itemQuantity,
+		LockedAt: time.Now(),
+	}
+
+	if ttl > 0 {
+		expiresAt := lock.LockedAt.Add(ttl)
+		lock.ExpiresAt = &expiresAt
+	}
+
+	item.Locks = append(item.Locks, lock)
+	item.UpdatedAt = time.Now()
+
+	item.record(&ItemLockedEvent{
+		ID:             item.ID,
+		OwnerID:        item.OwnerID,
+		LockedBy:       lock.LockedBy,
+		Quantity:       int64(lock.Quantity),
+		LockedQuantity: int64(item.GetLockedQuantity()),
+		ExpiresAt:      lock.ExpiresAt,
+		LockedAt:       lock.LockedAt,
 	})
 
-	item.Status = ItemPendingUpdateDispatch
+	return nil
+}
+
+// Unlock ...
+func (item *Item) Unlock(lockedBy string) error {
+	lock := item.removeLock(lockedBy)
+	if lock == nil {
+		return core.ErrNotFound
+	}
+
 	item.UpdatedAt = time.Now()
 
+	item.record(item.newItemUnlockedEvent(lock, ItemUnlockReleased))
+
 	return nil
 }
+
+// ReleaseTradeLock releases the lock held by the trade for the given reason
+// and reports whether there was one, so releasing it twice is a no-op
+func (item *Item) ReleaseTradeLock(tradeID string, reason ItemUnlockReason) bool {
+	lock := item.removeLock(tradeID)
+	if lock == nil {
+		return false
+	}
+
+	item.UpdatedAt = time.Now()
+
+	item.record(item.newItemUnlockedEvent(lock, reason))
+
+	return true
+}
+
+// GetLock returns the lock held by lockedBy or nil when there is none
+func (item *Item) GetLock(lockedBy string) *ItemLock {
+	for _, lock := range item.Locks {
+		if lock.LockedBy == lockedBy {
+			return lock
+		}
+	}
+
+	return nil
+}
+
+// ReleaseExpiredLocks removes every lock expired at the given time
+// and returns whether any lock was released
+func (item *Item) ReleaseExpiredLocks(now time.Time) bool {
+	var locks, expired []*ItemLock
+
+	for _, lock := range item.Locks {
+		if lock.IsExpired(now) {
+			expired = append(expired, lock)
+		} else {
+			locks = append(locks, lock)
+		}
+	}
+
+	if len(expired) == 0 {
+		return false
+	}
+
+	item.Locks = locks
+	item.UpdatedAt = time.Now()
+
+	for _, lock := range expired {
+		item.record(item.newItemUnlockedEvent(lock, ItemUnlockExpired))
+	}
+
+	return true
+}
+
+// ReleaseLocks removes every lock for the given reason
+// and returns whether any lock was released
+func (item *Item) ReleaseLocks(reason ItemUnlockReason) bool {
+	if len(item.Locks) == 0 {
+		return false
+	}
+
+	locks := item.Locks
+
+	item.Locks = nil
+	item.UpdatedAt = time.Now()
+
+	for _, lock := range locks {
+		item.record(item.newItemUnlockedEvent(lock, reason))
+	}
+
+	return true
+}
+
+// Transfer moves quantity of the item to a new item owned by ownerID,
+// releasing the lock held by the trade when there is one
+func (item *Item) Transfer(tradeID, newItemID, ownerID string, quantity int64) (*Item, error) {
+	itemQuantity, err := NewItemQuantity(quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	var released ItemQuantity
+	if lock := item.GetLock(tradeID); lock != nil {
+		released = lock.Quantity
+	}
+
+	if item.TotalQuantity-(item.GetLockedQuantity()-released) < itemQuantity {
+		return nil, core.ErrValidationFailed
+	}
+
+	newItem, err := NewItem(
+		newItemID, ownerID, string(item.Name),
+		(*string)(item.Description), quantity, ItemAvailable,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	item.removeLock(tradeID)
+	item.TotalQuantity = item.TotalQuantity - itemQuantity
+	item.UpdatedAt = time.Now()
+
+	item.record(&ItemTransferredEvent{
+		ID:             item.ID,
+		OwnerID:        item.OwnerID,
+		TradeID:        tradeID,
+		ToItemID:       newItem.ID,
+		ToOwnerID:      ownerID,
+		Quantity:       quantity,
+		TotalQuantity:  int64(item.TotalQuantity),
+		LockedQuantity: int64(item.GetLockedQuantity()),
+		TransferredAt:  item.UpdatedAt,
+	})
+
+	return newItem, nil
+}
+
+// Delete records the deletion of the item, removing it is
+// up to the repository
+func (item *Item) Delete() {
+	item.record(ParseItemToItemDeletedEvent(item))
+}
+
+// IsDeleted reports whether the deletion of the item has
+// been recorded since its events were last cleared
+func (item *Item) IsDeleted() bool {
+	for _, event := range item.events {
+		if _, ok := event.(*ItemDeletedEvent); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Anonymize records the deletion of the item and removes the data of its
+// owner, keeping the item for the trades it took part in. Its locks must be
+// released first
+func (item *Item) Anonymize() {
+	item.Delete()
+
+	item.OwnerID = AnonymizedOwnerID
+	item.Name = AnonymizedItemName
+	description := AnonymizedItemDescription
+	item.Description = &description
+	item.Status = ItemAnonymized
+	item.UpdatedAt = time.Now()
+}
+
+func (item *Item) removeLock(lockedBy string) *ItemLock {
+	for i, lock := range item.Locks {
+		if lock.LockedBy == lockedBy {
+			item.Locks = append(item.Locks[:i], item.Locks[i+1:]...)
+			return lock
+		}
+	}
+
+	return nil
+}
+
+func (item *Item) newItemUnlockedEvent(lock *ItemLock, reason ItemUnlockReason) *ItemUnlockedEvent {
+	return &ItemUnlockedEvent{
+		ID:             item.ID,
+		OwnerID:        item.OwnerID,
+		LockedBy:       lock.LockedBy,
+		Quantity:       int64(lock.Quantity),
+		LockedQuantity: int64(item.GetLockedQuantity()),
+		Reason:         reason,
+		UnlockedAt:     item.UpdatedAt,
+	}
+}
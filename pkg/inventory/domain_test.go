@@ -2,6 +2,7 @@ package inventory_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/bxcodec/faker/v3"
 	"github.com/d-leme/tradew-inventory-write/pkg/core"
@@ -100,6 +101,25 @@ func (s *domainTestSuite) TestUpdate() {
 	s.assert.Equal(quantity, int64(item.TotalQuantity))
 }
 
+func (s *domainTestSuite) TestCheckVersion() {
+	description := faker.Sentence()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(item)
+
+	s.assert.NoError(item.CheckVersion(1))
+	s.assert.ErrorIs(item.CheckVersion(2), core.ErrVersionConflict)
+}
+
 func (s *domainTestSuite) TestLock() {
 	description := faker.Sentence()
 	quantity := int64(5)
@@ -117,13 +137,35 @@ func (s *domainTestSuite) TestLock() {
 	s.assert.NotNil(item)
 
 	lockQuantity := int64(3)
-	err = item.Lock(uuid.NewString(), lockQuantity)
+	err = item.Lock(uuid.NewString(), lockQuantity, 0)
 
 	s.assert.NoError(err)
 	s.assert.Equal(quantity, int64(item.TotalQuantity))
 	s.assert.Equal(lockQuantity, int64(item.GetLockedQuantity()))
 }
 
+func (s *domainTestSuite) TestLockWithTTL() {
+	description := faker.Sentence()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(item)
+
+	err = item.Lock(uuid.NewString(), 3, time.Hour)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(item.Locks[0].ExpiresAt)
+	s.assert.Equal(item.Locks[0].LockedAt.Add(time.Hour), *item.Locks[0].ExpiresAt)
+}
+
 func (s *domainTestSuite) TestLockNotEnoughtItemsToLock() {
 	description := faker.Sentence()
 	quantity := int64(5)
@@ -140,9 +182,338 @@ func (s *domainTestSuite) TestLockNotEnoughtItemsToLock() {
 	s.assert.NoError(err)
 	s.assert.NotNil(item)
 
-	err = item.Lock(uuid.NewString(), 7)
+	err = item.Lock(uuid.NewString(), 7, 0)
 
 	s.assert.ErrorIs(err, core.ErrNotEnoughtItemsToLock)
 	s.assert.Equal(quantity, int64(item.TotalQuantity))
 	s.assert.Equal(int64(0), int64(item.GetLockedQuantity()))
 }
+
+func (s *domainTestSuite) TestUnlock() {
+	description := faker.Sentence()
+	lockedBy := uuid.NewString()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(item)
+
+	s.assert.NoError(item.Lock(lockedBy, 3, 0))
+	s.assert.NoError(item.Lock(uuid.NewString(), 1, 0))
+
+	err = item.Unlock(lockedBy)
+
+	s.assert.NoError(err)
+	s.assert.Len(item.Locks, 1)
+	s.assert.Equal(int64(1), int64(item.GetLockedQuantity()))
+}
+
+func (s *domainTestSuite) TestUnlockNotFound() {
+	description := faker.Sentence()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(item)
+
+	s.assert.NoError(item.Lock(uuid.NewString(), 3, 0))
+
+	err = item.Unlock(uuid.NewString())
+
+	s.assert.ErrorIs(err, core.ErrNotFound)
+	s.assert.Len(item.Locks, 1)
+}
+
+func (s *domainTestSuite) TestAnonymize() {
+	ownerID := uuid.NewString()
+
+	items := createItems(1, ownerID)
+	item := items[0]
+
+	s.assert.NoError(item.Lock(uuid.NewString(), 1, 0))
+	s.assert.NoError(item.Lock(uuid.NewString(), 2, 0))
+	item.ClearEvents()
+
+	s.assert.True(item.ReleaseLocks(inventory.ItemUnlockOwnerDeleted))
+	s.assert.False(item.ReleaseLocks(inventory.ItemUnlockOwnerDeleted))
+	item.Anonymize()
+
+	s.assert.Empty(item.Locks)
+	s.assert.Equal(inventory.AnonymizedOwnerID, item.OwnerID)
+	s.assert.Equal(inventory.AnonymizedItemName, item.Name)
+	s.assert.Equal(inventory.ItemAnonymized, item.Status)
+	s.assert.Equal(inventory.AnonymizedItemDescription, *item.Description)
+
+	events := item.Events()
+	s.assert.Len(events, 3)
+
+	for _, event := range events[:2] {
+		unlocked, ok := event.(*inventory.ItemUnlockedEvent)
+		s.assert.True(ok)
+		s.assert.Equal(inventory.ItemUnlockOwnerDeleted, unlocked.Reason)
+	}
+
+	deleted, ok := events[2].(*inventory.ItemDeletedEvent)
+	s.assert.True(ok)
+	s.assert.Equal(ownerID, deleted.OwnerID)
+}
+
+func (s *domainTestSuite) TestParseDeletionPolicy() {
+	policy, err := inventory.ParseDeletionPolicy("")
+	s.assert.NoError(err)
+	s.assert.Equal(inventory.DeletionPolicyDelete, policy)
+
+	policy, err = inventory.ParseDeletionPolicy("Anonymize")
+	s.assert.NoError(err)
+	s.assert.Equal(inventory.DeletionPolicyAnonymize, policy)
+
+	_, err = inventory.ParseDeletionPolicy("archive")
+	s.assert.ErrorIs(err, core.ErrValidationFailed)
+}
+
+func (s *domainTestSuite) TestReleaseTradeLock() {
+	tradeID := uuid.NewString()
+
+	items := createItems(1, uuid.NewString())
+	item := items[0]
+
+	s.assert.NoError(item.Lock(tradeID, 2, 0))
+	item.ClearEvents()
+
+	s.assert.True(item.ReleaseTradeLock(tradeID, inventory.ItemUnlockCancelled))
+	s.assert.Empty(item.Locks)
+	s.assert.Len(item.Events(), 1)
+
+	event, ok := item.Events()[0].(*inventory.ItemUnlockedEvent)
+	s.assert.True(ok)
+	s.assert.Equal(tradeID, event.LockedBy)
+	s.assert.Equal(inventory.ItemUnlockCancelled, event.Reason)
+
+	s.assert.False(item.ReleaseTradeLock(tradeID, inventory.ItemUnlockCancelled))
+	s.assert.Len(item.Events(), 1)
+}
+
+func (s *domainTestSuite) TestReleaseExpiredLocks() {
+	description := faker.Sentence()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(item)
+
+	s.assert.NoError(item.Lock(uuid.NewString(), 1, time.Minute))
+	s.assert.NoError(item.Lock(uuid.NewString(), 2, time.Hour))
+	s.assert.NoError(item.Lock(uuid.NewString(), 1, 0))
+
+	released := item.ReleaseExpiredLocks(time.Now().Add(10 * time.Minute))
+
+	s.assert.True(released)
+	s.assert.Len(item.Locks, 2)
+	s.assert.Equal(int64(3), int64(item.GetLockedQuantity()))
+
+	released = item.ReleaseExpiredLocks(time.Now().Add(10 * time.Minute))
+
+	s.assert.False(released)
+	s.assert.Len(item.Locks, 2)
+}
+
+func (s *domainTestSuite) TestEventsRecorded() {
+	description := faker.Sentence()
+	lockedBy := uuid.NewString()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NoError(item.Update(faker.Name(), &description, 6))
+	s.assert.NoError(item.Lock(lockedBy, 2, 0))
+	s.assert.NoError(item.Unlock(lockedBy))
+	item.Delete()
+
+	types := make([]inventory.EventType, len(item.Events()))
+	for i, event := range item.Events() {
+		s.assert.Equal(item.ID, event.AggregateID())
+		types[i] = event.Type()
+	}
+
+	s.assert.Equal([]inventory.EventType{
+		inventory.ItemCreatedEventType,
+		inventory.ItemUpdatedEventType,
+		inventory.ItemLockedEventType,
+		inventory.ItemUnlockedEventType,
+		inventory.ItemDeletedEventType,
+	}, types)
+
+	item.ClearEvents()
+	s.assert.Empty(item.Events())
+}
+
+func (s *domainTestSuite) TestIsDeleted() {
+	items := createItems(1, uuid.NewString())
+	item := items[0]
+
+	s.assert.False(item.IsDeleted())
+
+	item.Delete()
+	s.assert.True(item.IsDeleted())
+
+	// the deletion is no longer pending once persisted
+	item.ClearEvents()
+	s.assert.False(item.IsDeleted())
+}
+
+func (s *domainTestSuite) TestEventsSequence() {
+	items := createItems(1, uuid.NewString())
+	item := items[0]
+	lockedBy := uuid.NewString()
+
+	s.assert.NoError(item.Lock(lockedBy, 2, 0))
+	s.assert.NoError(item.Unlock(lockedBy))
+
+	s.assert.Equal(int64(3), item.Sequence)
+	s.assert.Equal(int64(1), item.Events()[0].(*inventory.ItemCreatedEvent).Sequence)
+	s.assert.Equal(int64(2), item.Events()[1].(*inventory.ItemLockedEvent).Sequence)
+	s.assert.Equal(int64(3), item.Events()[2].(*inventory.ItemUnlockedEvent).Sequence)
+
+	// snapshots carry the sequence of the last event
+	s.assert.Equal(int64(3), inventory.ParseItemToItemUpdatedEvent(item).Sequence)
+
+	item.ClearEvents()
+	item.Delete()
+
+	s.assert.Equal(int64(4), item.Events()[0].(*inventory.ItemDeletedEvent).Sequence)
+}
+
+func (s *domainTestSuite) TestEventsVersion() {
+	items := createItems(1, uuid.NewString())
+	item := items[0]
+
+	// new items are inserted with their version, even
+	// when changed again before being inserted
+	s.assert.NoError(item.Update("new name", nil, 3))
+	s.assert.Equal(int64(1), item.Events()[0].(*inventory.ItemCreatedEvent).Version)
+	s.assert.Equal(int64(1), item.Events()[1].(*inventory.ItemUpdatedEvent).Version)
+
+	item.ClearEvents()
+	item.MarkPersisted()
+	s.assert.NoError(item.Update("other name", nil, 4))
+	s.assert.NoError(item.Update("last name", nil, 5))
+
+	// persisted items are updated to the next one
+	s.assert.Equal(int64(2), item.Events()[0].(*inventory.ItemUpdatedEvent).Version)
+	s.assert.Equal(int64(2), item.Events()[1].(*inventory.ItemUpdatedEvent).Version)
+
+	// snapshots carry the saved version
+	s.assert.Equal(int64(1), inventory.ParseItemToItemUpdatedEvent(item).Version)
+}
+
+func (s *domainTestSuite) TestEventsVersionOfLoadedItem() {
+	description := inventory.ItemDescription("my old bike")
+
+	// items saved before events were numbered are loaded with no sequence
+	item := &inventory.Item{
+		ID:            uuid.NewString(),
+		OwnerID:       uuid.NewString(),
+		Name:          "bike",
+		Status:        inventory.ItemAvailable,
+		Description:   &description,
+		TotalQuantity: 2,
+		Version:       4,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	item.MarkPersisted()
+
+	s.assert.NoError(item.Update("new name", nil, 3))
+
+	event := item.Events()[0].(*inventory.ItemUpdatedEvent)
+	s.assert.Equal(int64(5), event.Version)
+	s.assert.Equal(int64(1), event.Sequence)
+}
+
+func (s *domainTestSuite) TestTransfer() {
+	description := faker.Sentence()
+	tradeID := uuid.NewString()
+	ownerID := uuid.NewString()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NoError(item.Lock(tradeID, 3, 0))
+	s.assert.NoError(item.Lock(uuid.NewString(), 1, 0))
+	item.ClearEvents()
+
+	newItem, err := item.Transfer(tradeID, uuid.NewString(), ownerID, 3)
+
+	s.assert.NoError(err)
+	s.assert.Equal(ownerID, newItem.OwnerID)
+	s.assert.Equal(int64(3), int64(newItem.TotalQuantity))
+	s.assert.Equal(int64(2), int64(item.TotalQuantity))
+	s.assert.Equal(int64(1), int64(item.GetLockedQuantity()))
+	s.assert.Nil(item.GetLock(tradeID))
+
+	s.assert.Len(item.Events(), 1)
+	event := item.Events()[0].(*inventory.ItemTransferredEvent)
+	s.assert.Equal(newItem.ID, event.ToItemID)
+	s.assert.Equal(ownerID, event.ToOwnerID)
+	s.assert.Equal(int64(3), event.Quantity)
+
+	s.assert.Len(newItem.Events(), 1)
+	s.assert.Equal(inventory.ItemCreatedEventType, newItem.Events()[0].Type())
+}
+
+func (s *domainTestSuite) TestTransferLockedByOthers() {
+	description := faker.Sentence()
+
+	item, err := inventory.NewItem(
+		uuid.NewString(),
+		uuid.NewString(),
+		faker.Name(),
+		&description,
+		5,
+		inventory.ItemAvailable,
+	)
+
+	s.assert.NoError(err)
+	s.assert.NoError(item.Lock(uuid.NewString(), 3, 0))
+
+	newItem, err := item.Transfer(uuid.NewString(), uuid.NewString(), uuid.NewString(), 3)
+
+	s.assert.ErrorIs(err, core.ErrValidationFailed)
+	s.assert.Nil(newItem)
+	s.assert.Equal(int64(5), int64(item.TotalQuantity))
+}
@@ -1,6 +1,86 @@
 package inventory
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory/proto"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EventType ...
+type EventType string
+
+const (
+	// ItemCreatedEventType is the type of the events recorded
+	// when an item is created, including items received by trades
+	ItemCreatedEventType EventType = "ItemCreated"
+
+	// ItemUpdatedEventType is the type of the events recorded
+	// when the name, description or quantity of an item changes
+	ItemUpdatedEventType EventType = "ItemUpdated"
+
+	// ItemLockedEventType is the type of the events recorded
+	// when a quantity of an item is locked by a trade
+	ItemLockedEventType EventType = "ItemLocked"
+
+	// ItemUnlockedEventType is the type of the events recorded
+	// when a lock is released or expires
+	ItemUnlockedEventType EventType = "ItemUnlocked"
+
+	// ItemTransferredEventType is the type of the events recorded
+	// when a quantity of an item is traded to another user
+	ItemTransferredEventType EventType = "ItemTransferred"
+
+	// ItemDeletedEventType is the type of the events recorded
+	// when an item is removed
+	ItemDeletedEventType EventType = "ItemDeleted"
+
+	// ItemsUpdatedEventType is the type of the snapshots
+	// published to the items-updated topic
+	ItemsUpdatedEventType EventType = "ItemsUpdated"
+
+	// ItemsDeletedEventType is the type of the snapshots
+	// published to the items-deleted topic
+	ItemsDeletedEventType EventType = "ItemsDeleted"
+)
+
+// ItemUnlockReason ...
+type ItemUnlockReason string
+
+const (
+	// ItemUnlockReleased is set when the lock was released explicitly
+	ItemUnlockReleased ItemUnlockReason = "Released"
+
+	// ItemUnlockExpired is set when the lock ttl has expired
+	ItemUnlockExpired ItemUnlockReason = "Expired"
+
+	// ItemUnlockCancelled is set when the trade holding the lock was cancelled
+	ItemUnlockCancelled ItemUnlockReason = "Cancelled"
+
+	// ItemUnlockRejected is set when the trade holding the lock was rejected
+	ItemUnlockRejected ItemUnlockReason = "Rejected"
+
+	// ItemUnlockOwnerDeleted is set when the account of the item owner was deleted
+	ItemUnlockOwnerDeleted ItemUnlockReason = "OwnerDeleted"
+)
+
+// Event is a domain event recorded by an Item
+type Event interface {
+	Type() EventType
+	AggregateID() string
+	setSequence(sequence int64)
+}
+
+// versionedEvent is an Event carrying the version of the item,
+// the version the item has once the event is saved
+type versionedEvent interface {
+	setVersion(version int64)
+}
+
+// ItemCreatedEvent ...
+type ItemCreatedEvent ItemUpdatedEvent
 
 // ItemUpdatedEvent ...
 type ItemUpdatedEvent struct {
@@ -12,6 +92,8 @@ type ItemUpdatedEvent struct {
 	LockedQuantity int64     `json:"locked_quantity"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	Sequence       int64     `json:"sequence"`
+	Version        int64     `json:"version"`
 }
 
 // ItemsUpdatedEvent ...
@@ -19,29 +101,270 @@ type ItemsUpdatedEvent struct {
 	Items []*ItemUpdatedEvent `json:"items"`
 }
 
+// ItemLockedEvent ...
+type ItemLockedEvent struct {
+	ID             string     `json:"id"`
+	OwnerID        string     `json:"owner_id"`
+	LockedBy       string     `json:"locked_by"`
+	Quantity       int64      `json:"quantity"`
+	LockedQuantity int64      `json:"locked_quantity"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	LockedAt       time.Time  `json:"locked_at"`
+	Sequence       int64      `json:"sequence"`
+}
+
+// ItemUnlockedEvent ...
+type ItemUnlockedEvent struct {
+	ID             string           `json:"id"`
+	OwnerID        string           `json:"owner_id"`
+	LockedBy       string           `json:"locked_by"`
+	Quantity       int64            `json:"quantity"`
+	LockedQuantity int64            `json:"locked_quantity"`
+	Reason         ItemUnlockReason `json:"reason"`
+	UnlockedAt     time.Time        `json:"unlocked_at"`
+	Sequence       int64            `json:"sequence"`
+}
+
+// ItemTransferredEvent ...
+type ItemTransferredEvent struct {
+	ID             string    `json:"id"`
+	OwnerID        string    `json:"owner_id"`
+	TradeID        string    `json:"trade_id"`
+	ToItemID       string    `json:"to_item_id"`
+	ToOwnerID      string    `json:"to_owner_id"`
+	Quantity       int64     `json:"quantity"`
+	TotalQuantity  int64     `json:"total_quantity"`
+	LockedQuantity int64     `json:"locked_quantity"`
+	TransferredAt  time.Time `json:"transferred_at"`
+	Sequence       int64     `json:"sequence"`
+}
+
+// ItemDeletedEvent ...
+type ItemDeletedEvent struct {
+	ID        string    `json:"id"`
+	OwnerID   string    `json:"owner_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Sequence  int64     `json:"sequence"`
+}
+
+// ItemsDeletedEvent ...
+type ItemsDeletedEvent struct {
+	Items []*ItemDeletedEvent `json:"items"`
+}
+
+// Type ...
+func (e *ItemCreatedEvent) Type() EventType { return ItemCreatedEventType }
+
+// AggregateID ...
+func (e *ItemCreatedEvent) AggregateID() string { return e.ID }
+
+func (e *ItemCreatedEvent) setSequence(sequence int64) { e.Sequence = sequence }
+
+func (e *ItemCreatedEvent) setVersion(version int64) { e.Version = version }
+
+// Type ...
+func (e *ItemUpdatedEvent) Type() EventType { return ItemUpdatedEventType }
+
+// AggregateID ...
+func (e *ItemUpdatedEvent) AggregateID() string { return e.ID }
+
+func (e *ItemUpdatedEvent) setSequence(sequence int64) { e.Sequence = sequence }
+
+func (e *ItemUpdatedEvent) setVersion(version int64) { e.Version = version }
+
+// Type ...
+func (e *ItemLockedEvent) Type() EventType { return ItemLockedEventType }
+
+// AggregateID ...
+func (e *ItemLockedEvent) AggregateID() string { return e.ID }
+
+func (e *ItemLockedEvent) setSequence(sequence int64) { e.Sequence = sequence }
+
+// Type ...
+func (e *ItemUnlockedEvent) Type() EventType { return ItemUnlockedEventType }
+
+// AggregateID ...
+func (e *ItemUnlockedEvent) AggregateID() string { return e.ID }
+
+func (e *ItemUnlockedEvent) setSequence(sequence int64) { e.Sequence = sequence }
+
+// Type ...
+func (e *ItemTransferredEvent) Type() EventType { return ItemTransferredEventType }
+
+// AggregateID ...
+func (e *ItemTransferredEvent) AggregateID() string { return e.ID }
+
+func (e *ItemTransferredEvent) setSequence(sequence int64) { e.Sequence = sequence }
+
+// Type ...
+func (e *ItemDeletedEvent) Type() EventType { return ItemDeletedEventType }
+
+// AggregateID ...
+func (e *ItemDeletedEvent) AggregateID() string { return e.ID }
+
+func (e *ItemDeletedEvent) setSequence(sequence int64) { e.Sequence = sequence }
+
+// ParseItemToItemUpdatedEvent ...
+func ParseItemToItemUpdatedEvent(item *Item) *ItemUpdatedEvent {
+	return &ItemUpdatedEvent{
+		ID:             item.ID,
+		OwnerID:        item.OwnerID,
+		Name:           string(item.Name),
+		Description:    (*string)(item.Description),
+		TotalQuantity:  int64(item.TotalQuantity),
+		LockedQuantity: int64(item.GetLockedQuantity()),
+		CreatedAt:      item.CreatedAt,
+		UpdatedAt:      item.UpdatedAt,
+		Sequence:       item.Sequence,
+		Version:        item.Version,
+	}
+}
+
 // ParseItemsToItemsUpdatedEvent ...
 func ParseItemsToItemsUpdatedEvent(s []*Item) *ItemsUpdatedEvent {
 
 	items := make([]*ItemUpdatedEvent, len(s))
 
 	for i, item := range s {
+		items[i] = ParseItemToItemUpdatedEvent(item)
+	}
 
-		var lockedQuantity int64
-		for _, lock := range item.Locks {
-			lockedQuantity = lockedQuantity + int64(lock.Quantity)
-		}
+	return &ItemsUpdatedEvent{Items: items}
+}
+
+// ParseItemToItemDeletedEvent ...
+func ParseItemToItemDeletedEvent(item *Item) *ItemDeletedEvent {
+	return &ItemDeletedEvent{
+		ID:        item.ID,
+		OwnerID:   item.OwnerID,
+		DeletedAt: time.Now(),
+		Sequence:  item.Sequence,
+	}
+}
+
+// ParseOutboxToItemsDeletedEvent ...
+func ParseOutboxToItemsDeletedEvent(messages []*OutboxMessage) (*ItemsDeletedEvent, error) {
+
+	items := make([]*ItemDeletedEvent, len(messages))
+
+	for i, message := range messages {
+		item := new(ItemDeletedEvent)
 
-		items[i] = &ItemUpdatedEvent{
-			ID:             item.ID,
-			OwnerID:        item.OwnerID,
-			Name:           string(item.Name),
-			Description:    (*string)(item.Description),
-			TotalQuantity:  int64(item.TotalQuantity),
-			LockedQuantity: lockedQuantity,
-			CreatedAt:      item.CreatedAt,
-			UpdatedAt:      item.UpdatedAt,
+		if err := json.Unmarshal(message.Payload, item); err != nil {
+			return nil, err
 		}
+
+		items[i] = item
 	}
 
-	return &ItemsUpdatedEvent{Items: items}
+	return &ItemsDeletedEvent{Items: items}, nil
+}
+
+// Proto returns the protobuf representation of the event, ItemCreated
+// events share the ItemUpdatedEvent message
+func (e *ItemCreatedEvent) Proto() protobuf.Message {
+	return (*ItemUpdatedEvent)(e).Proto()
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemUpdatedEvent) Proto() protobuf.Message {
+	return e.toProto()
+}
+
+func (e *ItemUpdatedEvent) toProto() *proto.ItemUpdatedEvent {
+	return &proto.ItemUpdatedEvent{
+		Id:             e.ID,
+		OwnerId:        e.OwnerID,
+		Name:           e.Name,
+		Description:    e.Description,
+		TotalQuantity:  e.TotalQuantity,
+		LockedQuantity: e.LockedQuantity,
+		CreatedAt:      timestamppb.New(e.CreatedAt),
+		UpdatedAt:      timestamppb.New(e.UpdatedAt),
+		Sequence:       e.Sequence,
+		Version:        e.Version,
+	}
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemsUpdatedEvent) Proto() protobuf.Message {
+	items := make([]*proto.ItemUpdatedEvent, len(e.Items))
+	for i, item := range e.Items {
+		items[i] = item.toProto()
+	}
+
+	return &proto.ItemsUpdatedEvent{Items: items}
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemLockedEvent) Proto() protobuf.Message {
+	event := &proto.ItemLockedEvent{
+		Id:             e.ID,
+		OwnerId:        e.OwnerID,
+		LockedBy:       e.LockedBy,
+		Quantity:       e.Quantity,
+		LockedQuantity: e.LockedQuantity,
+		LockedAt:       timestamppb.New(e.LockedAt),
+		Sequence:       e.Sequence,
+	}
+
+	if e.ExpiresAt != nil {
+		event.ExpiresAt = timestamppb.New(*e.ExpiresAt)
+	}
+
+	return event
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemUnlockedEvent) Proto() protobuf.Message {
+	return &proto.ItemUnlockedEvent{
+		Id:             e.ID,
+		OwnerId:        e.OwnerID,
+		LockedBy:       e.LockedBy,
+		Quantity:       e.Quantity,
+		LockedQuantity: e.LockedQuantity,
+		Reason:         string(e.Reason),
+		UnlockedAt:     timestamppb.New(e.UnlockedAt),
+		Sequence:       e.Sequence,
+	}
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemTransferredEvent) Proto() protobuf.Message {
+	return &proto.ItemTransferredEvent{
+		Id:             e.ID,
+		OwnerId:        e.OwnerID,
+		TradeId:        e.TradeID,
+		ToItemId:       e.ToItemID,
+		ToOwnerId:      e.ToOwnerID,
+		Quantity:       e.Quantity,
+		TotalQuantity:  e.TotalQuantity,
+		LockedQuantity: e.LockedQuantity,
+		TransferredAt:  timestamppb.New(e.TransferredAt),
+		Sequence:       e.Sequence,
+	}
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemDeletedEvent) Proto() protobuf.Message {
+	return e.toProto()
+}
+
+func (e *ItemDeletedEvent) toProto() *proto.ItemDeletedEvent {
+	return &proto.ItemDeletedEvent{
+		Id:        e.ID,
+		OwnerId:   e.OwnerID,
+		DeletedAt: timestamppb.New(e.DeletedAt),
+		Sequence:  e.Sequence,
+	}
+}
+
+// Proto returns the protobuf representation of the event
+func (e *ItemsDeletedEvent) Proto() protobuf.Message {
+	items := make([]*proto.ItemDeletedEvent, len(e.Items))
+	for i, item := range e.Items {
+		items[i] = item.toProto()
+	}
+
+	return &proto.ItemsDeletedEvent{Items: items}
 }
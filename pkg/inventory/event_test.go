@@ -0,0 +1,149 @@
+package inventory_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bxcodec/faker/v3"
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type eventTestSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+}
+
+func TestEventTestSuite(t *testing.T) {
+	suite.Run(t, new(eventTestSuite))
+}
+
+func (s *eventTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+}
+
+func (s *eventTestSuite) TestNewOutboxMessage() {
+	items := createItems(1, uuid.NewString())
+	s.assert.NoError(items[0].Lock(uuid.NewString(), 2, 0))
+
+	events := items[0].Events()
+	s.assert.Len(events, 2)
+
+	message, err := inventory.NewOutboxMessage(events[1])
+
+	s.assert.NoError(err)
+	s.assert.Equal(items[0].ID, message.AggregateID)
+	s.assert.Equal(inventory.ItemLockedEventType, message.EventType)
+
+	event := new(inventory.ItemLockedEvent)
+	s.assert.NoError(json.Unmarshal(message.Payload, event))
+	s.assert.Equal(int64(2), event.Quantity)
+	s.assert.Equal(int64(2), event.LockedQuantity)
+}
+
+func (s *eventTestSuite) TestParseOutboxToItemsDeletedEventInvalidPayload() {
+	messages := []*inventory.OutboxMessage{
+		{
+			AggregateID: uuid.NewString(),
+			EventType:   inventory.ItemDeletedEventType,
+			Payload:     []byte(faker.Word()),
+		},
+	}
+
+	event, err := inventory.ParseOutboxToItemsDeletedEvent(messages)
+
+	s.assert.Error(err)
+	s.assert.Nil(event)
+}
+
+func (s *eventTestSuite) TestParseOutboxToItemsDeletedEvent() {
+	items := createItems(2, uuid.NewString())
+	messages := make([]*inventory.OutboxMessage, len(items))
+
+	for i, item := range items {
+		message, err := inventory.NewOutboxMessage(inventory.ParseItemToItemDeletedEvent(item))
+
+		s.assert.NoError(err)
+		s.assert.Equal(inventory.ItemDeletedEventType, message.EventType)
+
+		messages[i] = message
+	}
+
+	event, err := inventory.ParseOutboxToItemsDeletedEvent(messages)
+
+	s.assert.NoError(err)
+	s.assert.Len(event.Items, len(items))
+
+	for i, item := range items {
+		s.assert.Equal(item.ID, event.Items[i].ID)
+		s.assert.Equal(item.OwnerID, event.Items[i].OwnerID)
+	}
+}
+
+func (s *eventTestSuite) TestSplitOutboxByEventType() {
+	updated := &inventory.OutboxMessage{EventType: inventory.ItemUpdatedEventType}
+	deleted := &inventory.OutboxMessage{EventType: inventory.ItemDeletedEventType}
+
+	messages := []*inventory.OutboxMessage{updated, updated, deleted, updated, deleted, deleted}
+
+	groups := inventory.SplitOutboxByEventType(messages)
+
+	s.assert.Len(groups, 4)
+	s.assert.Len(groups[0], 2)
+	s.assert.Len(groups[1], 1)
+	s.assert.Len(groups[2], 1)
+	s.assert.Len(groups[3], 2)
+	s.assert.Equal(inventory.ItemDeletedEventType, groups[3][0].EventType)
+
+	s.assert.Empty(inventory.SplitOutboxByEventType(nil))
+}
+
+func (s *eventTestSuite) TestAggregateIDs() {
+	messages := []*inventory.OutboxMessage{
+		{AggregateID: "b"},
+		{AggregateID: "a"},
+		{AggregateID: "b"},
+		{AggregateID: "c"},
+	}
+
+	s.assert.Equal([]string{"b", "a", "c"}, inventory.AggregateIDs(messages))
+}
+
+func (s *eventTestSuite) TestOutboxIDs() {
+	messages := []*inventory.OutboxMessage{{ID: 3}, {ID: 1}, {ID: 2}}
+
+	s.assert.Equal([]int64{3, 1, 2}, inventory.OutboxIDs(messages))
+}
+
+func (s *eventTestSuite) TestDecodeCloudEvent() {
+	items := createItems(1, uuid.NewString())
+
+	message, err := inventory.NewOutboxMessage(items[0].Events()[0])
+	s.assert.NoError(err)
+
+	event, err := inventory.NewCloudEvent("1", message.EventType, message.AggregateID, message.CreatedAt, json.RawMessage(message.Payload))
+	s.assert.NoError(err)
+	s.assert.Equal("urn:tradew:inventory:ItemCreated:v1", event.DataSchema)
+
+	eventType, data, err := inventory.DecodeCloudEvent(event)
+
+	s.assert.NoError(err)
+	s.assert.Equal(inventory.ItemCreatedEventType, eventType)
+	s.assert.Equal(items[0].ID, data.(*inventory.ItemCreatedEvent).ID)
+	s.assert.Equal(int64(1), data.(*inventory.ItemCreatedEvent).Sequence)
+}
+
+func (s *eventTestSuite) TestDecodeCloudEventUnknownSchema() {
+	event, err := inventory.NewCloudEvent("1", inventory.ItemCreatedEventType, "", time.Now(), json.RawMessage(`{}`))
+	s.assert.NoError(err)
+
+	event.DataSchema = inventory.EventSchema(inventory.ItemCreatedEventType, 99)
+
+	_, _, err = inventory.DecodeCloudEvent(event)
+
+	s.assert.Equal(core.ErrValidationFailed, err)
+}
@@ -2,6 +2,7 @@ package inventory
 
 import (
 	"context"
+	"time"
 
 	"github.com/d-leme/tradew-inventory-write/pkg/inventory/proto"
 	"github.com/sirupsen/logrus"
@@ -29,6 +30,7 @@ func (s *grpcService) LockItems(ctx context.Context, req *proto.LockItemsRequest
 		OwnerID:            req.OwnerID,
 		LockedBy:           req.LockedBy,
 		WantedItemsOwnerID: req.WantedItemsOwnerID,
+		TTL:                time.Duration(req.TtlSeconds) * time.Second,
 		OfferedItems:       make([]*LockItemModel, len(req.OfferedItems)),
 		WantedItems:        make([]*LockItemModel, len(req.WantedItems)),
 	}
@@ -54,6 +56,24 @@ func (s *grpcService) LockItems(ctx context.Context, req *proto.LockItemsRequest
 	return &proto.Empty{}, nil
 }
 
+// UnlockItems ...
+func (s *grpcService) UnlockItems(ctx context.Context, req *proto.UnlockItemsRequest) (*proto.Empty, error) {
+
+	logrus.Info("unlock items called by GRPC")
+
+	servReq := &UnlockItemsRequest{
+		LockedBy: req.LockedBy,
+		OwnerID:  req.OwnerID,
+		IDs:      req.ItemIDs,
+	}
+
+	if err := s.service.UnlockItems(ctx, servReq); err != nil {
+		return nil, err
+	}
+
+	return &proto.Empty{}, nil
+}
+
 // TradeItems ...
 func (s *grpcService) TradeItems(ctx context.Context, req *proto.TradeItemsRequest) (*proto.Empty, error) {
 
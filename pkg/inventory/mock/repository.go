@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
 	"github.com/stretchr/testify/mock"
@@ -17,6 +18,11 @@ func NewRepository() inventory.Repository {
 	return &RepositoryMock{}
 }
 
+// WithTransaction runs fn straight away, the mock has no transaction to commit or rollback
+func (r *RepositoryMock) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
 // InsertBulk ...
 func (r *RepositoryMock) InsertBulk(ctx context.Context, items []*inventory.Item) error {
 	args := r.Mock.Called(items)
@@ -42,8 +48,8 @@ func (r *RepositoryMock) UpdateBulk(ctx context.Context, items []*inventory.Item
 }
 
 // DeleteBulk ...
-func (r *RepositoryMock) DeleteBulk(ctx context.Context, ids []string) error {
-	args := r.Mock.Called(ids)
+func (r *RepositoryMock) DeleteBulk(ctx context.Context, items []*inventory.Item) error {
+	args := r.Mock.Called(items)
 
 	arg0 := args.Get(0)
 	if arg0 != nil {
@@ -67,9 +73,37 @@ func (r *RepositoryMock) Get(ctx context.Context, userID *string, ids []string)
 	return nil, arg1.(error)
 }
 
-// GetByStatus ...
-func (r *RepositoryMock) GetByStatus(ctx context.Context, status inventory.ItemStatus) ([]*inventory.Item, error) {
-	args := r.Mock.Called()
+// GetForUpdate ...
+func (r *RepositoryMock) GetForUpdate(ctx context.Context, userID *string, ids []string) ([]*inventory.Item, error) {
+	args := r.Mock.Called(ids)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.([]*inventory.Item), nil
+	}
+
+	arg1 := args.Get(1)
+
+	return nil, arg1.(error)
+}
+
+// GetWithExpiredLocks ...
+func (r *RepositoryMock) GetWithExpiredLocks(ctx context.Context, until time.Time, limit int) ([]*inventory.Item, error) {
+	args := r.Mock.Called(limit)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.([]*inventory.Item), nil
+	}
+
+	arg1 := args.Get(1)
+
+	return nil, arg1.(error)
+}
+
+// GetByOwnerForUpdate ...
+func (r *RepositoryMock) GetByOwnerForUpdate(ctx context.Context, ownerID string) ([]*inventory.Item, error) {
+	args := r.Mock.Called(ownerID)
 
 	arg0 := args.Get(0)
 	if arg0 != nil {
@@ -80,3 +114,83 @@ func (r *RepositoryMock) GetByStatus(ctx context.Context, status inventory.ItemS
 
 	return nil, arg1.(error)
 }
+
+// GetLockedByForUpdate ...
+func (r *RepositoryMock) GetLockedByForUpdate(ctx context.Context, lockedBy string) ([]*inventory.Item, error) {
+	args := r.Mock.Called(lockedBy)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.([]*inventory.Item), nil
+	}
+
+	arg1 := args.Get(1)
+
+	return nil, arg1.(error)
+}
+
+// ListItems ...
+func (r *RepositoryMock) ListItems(ctx context.Context, filter *inventory.ItemFilter, after string, limit int) ([]*inventory.Item, error) {
+	args := r.Mock.Called(filter, after, limit)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.([]*inventory.Item), nil
+	}
+
+	arg1 := args.Get(1)
+
+	return nil, arg1.(error)
+}
+
+// ClaimOutbox ...
+func (r *RepositoryMock) ClaimOutbox(ctx context.Context, workerID string, limit int, lease time.Duration) ([]*inventory.OutboxMessage, error) {
+	args := r.Mock.Called(limit)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.([]*inventory.OutboxMessage), nil
+	}
+
+	arg1 := args.Get(1)
+
+	return nil, arg1.(error)
+}
+
+// ReleaseOutbox ...
+func (r *RepositoryMock) ReleaseOutbox(ctx context.Context, workerID string, ids []int64) error {
+	args := r.Mock.Called(ids)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.(error)
+	}
+
+	return nil
+}
+
+// MarkOutboxSent ...
+func (r *RepositoryMock) MarkOutboxSent(ctx context.Context, ids []int64) error {
+	args := r.Mock.Called(ids)
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.(error)
+	}
+
+	return nil
+}
+
+// ListenOutbox ...
+func (r *RepositoryMock) ListenOutbox(ctx context.Context) (<-chan struct{}, error) {
+	args := r.Mock.Called()
+
+	arg0 := args.Get(0)
+	if arg0 != nil {
+		return arg0.(chan struct{}), nil
+	}
+
+	arg1 := args.Get(1)
+
+	return nil, arg1.(error)
+}
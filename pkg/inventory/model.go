@@ -1,5 +1,7 @@
 package inventory
 
+import "time"
+
 // CreateItemModel ...
 type CreateItemModel struct {
 	Name        string  `json:"name"`
@@ -18,6 +20,7 @@ type UpdateItemModel struct {
 	Name        string  `json:"name"`
 	Description *string `json:"description"`
 	Quantity    int64   `json:"quantity"`
+	Version     *int64  `json:"version"`
 }
 
 // UpdateItemsRequest ...
@@ -36,10 +39,18 @@ type LockItemsRequest struct {
 	LockedBy           string
 	OwnerID            string
 	WantedItemsOwnerID string
+	TTL                time.Duration
 	OfferedItems       []*LockItemModel
 	WantedItems        []*LockItemModel
 }
 
+// UnlockItemsRequest ...
+type UnlockItemsRequest struct {
+	LockedBy string   `json:"locked_by"`
+	OwnerID  string   `json:"-"`
+	IDs      []string `json:"ids"`
+}
+
 // TradeItemModel ...
 type TradeItemModel struct {
 	ID       string
@@ -59,3 +70,15 @@ type TradeItemsRequest struct {
 type DeleteItemsRequest struct {
 	IDs []string `json:"ids"`
 }
+
+// ReleaseTradeLocksRequest ...
+type ReleaseTradeLocksRequest struct {
+	TradeID string
+	Reason  ItemUnlockReason
+}
+
+// DeleteOwnerItemsRequest ...
+type DeleteOwnerItemsRequest struct {
+	OwnerID string
+	Policy  DeletionPolicy
+}
@@ -0,0 +1,76 @@
+package inventory
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxMessage is an event waiting to be published, it is written in
+// the same transaction as the item change that produced it
+type OutboxMessage struct {
+	ID            int64
+	AggregateID   string
+	EventType     EventType
+	Payload       []byte
+	CorrelationID string
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+// NewOutboxMessage ...
+func NewOutboxMessage(event Event) (*OutboxMessage, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxMessage{
+		AggregateID: event.AggregateID(),
+		EventType:   event.Type(),
+		Payload:     payload,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// SplitOutboxByEventType splits messages into consecutive runs
+// of the same event type, keeping their order
+func SplitOutboxByEventType(messages []*OutboxMessage) [][]*OutboxMessage {
+	var groups [][]*OutboxMessage
+
+	for i, message := range messages {
+		if i == 0 || messages[i-1].EventType != message.EventType {
+			groups = append(groups, []*OutboxMessage{})
+		}
+
+		last := len(groups) - 1
+		groups[last] = append(groups[last], message)
+	}
+
+	return groups
+}
+
+// AggregateIDs returns the distinct aggregate ids of messages in order
+func AggregateIDs(messages []*OutboxMessage) []string {
+	var ids []string
+	seen := map[string]bool{}
+
+	for _, message := range messages {
+		if !seen[message.AggregateID] {
+			seen[message.AggregateID] = true
+			ids = append(ids, message.AggregateID)
+		}
+	}
+
+	return ids
+}
+
+// OutboxIDs ...
+func OutboxIDs(messages []*OutboxMessage) []int64 {
+	ids := make([]int64, len(messages))
+
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+
+	return ids
+}
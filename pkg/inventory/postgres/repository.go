@@ -2,17 +2,47 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
 	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
 )
 
 type repositoryPostgres struct {
 	pool *pgxpool.Pool
 }
 
+type txKey struct{}
+
+const (
+	// outboxChannel is notified whenever new messages are written to the outbox
+	outboxChannel = "outbox"
+
+	// outboxClaimLock names the advisory lock serializing the outbox claims
+	outboxClaimLock = "outbox_claim"
+
+	// listenMinBackoff and listenMaxBackoff bound the wait before listening
+	// again to the outbox channel once its connection is lost
+	listenMinBackoff = 100 * time.Millisecond
+	listenMaxBackoff = 30 * time.Second
+)
+
+// querier is implemented by both *pgxpool.Pool and pgx.Tx
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
 // NewRepository ...
 func NewRepository(pool *pgxpool.Pool) inventory.Repository {
 	return &repositoryPostgres{
@@ -20,6 +50,26 @@ func NewRepository(pool *pgxpool.Pool) inventory.Repository {
 	}
 }
 
+// WithTransaction ...
+func (r *repositoryPostgres) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return mapError(err)
+	}
+
+	return mapError(tx.Commit(ctx))
+}
+
 // InsertBulk ...
 func (r *repositoryPostgres) InsertBulk(ctx context.Context, items []*inventory.Item) error {
 
@@ -27,14 +77,14 @@ func (r *repositoryPostgres) InsertBulk(ctx context.Context, items []*inventory.
 
 	sqlItems := `
 		insert into
-		items(id, owner_id, name, status, description, total_quantity, created_at, updated_at)
-		values($1, $2, $3, $4, $5, $6, $7, $8)
+		items(id, owner_id, name, status, description, total_quantity, created_at, updated_at, version, sequence)
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`
 
 	sqlLocks := `
 		insert into
-		item_locks(item_id, locked_by, quantity)
-		values($1, $2, $3)
+		item_locks(item_id, locked_by, quantity, locked_at, expires_at)
+		values($1, $2, $3, $4, $5)
 	`
 
 	for _, i := range items {
@@ -48,6 +98,8 @@ func (r *repositoryPostgres) InsertBulk(ctx context.Context, items []*inventory.
 			i.TotalQuantity,
 			i.CreatedAt,
 			i.UpdatedAt,
+			i.Version,
+			i.Sequence,
 		)
 
 		for _, l := range i.Locks {
@@ -56,31 +108,33 @@ func (r *repositoryPostgres) InsertBulk(ctx context.Context, items []*inventory.
 				i.ID,
 				l.LockedBy,
 				l.Quantity,
+				l.LockedAt,
+				l.ExpiresAt,
 			)
 		}
-	}
 
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
+		if err := queueOutbox(ctx, batch, i); err != nil {
+			return err
+		}
 	}
 
-	res := tx.SendBatch(ctx, batch)
+	queueNotify(batch, items)
 
-	if _, err := res.Exec(); err != nil {
-		return err
-	}
-
-	res.Close()
+	err := r.transaction(ctx, func(tx pgx.Tx) error {
+		return sendBatch(ctx, tx, batch)
+	})
 
-	if err := tx.Commit(ctx); err != nil {
+	if err != nil {
 		return err
 	}
 
+	clearEvents(items)
+
 	return nil
 }
 
-// UpdateBulk ...
+// UpdateBulk fails with core.ErrVersionConflict when any item
+// has been changed since it was read
 func (r *repositoryPostgres) UpdateBulk(ctx context.Context, items []*inventory.Item) error {
 
 	batch := &pgx.Batch{}
@@ -88,14 +142,17 @@ func (r *repositoryPostgres) UpdateBulk(ctx context.Context, items []*inventory.
 	sqlItems := `
 		update items
 		set
-			name = $1,
-			status = $2,
-			description = $3,
-			total_quantity = $4,
-			created_at = $5,
-			updated_at = $6
+			owner_id = $1,
+			name = $2,
+			status = $3,
+			description = $4,
+			total_quantity = $5,
+			created_at = $6,
+			updated_at = $7,
+			sequence = $8,
+			version = version + 1
 		where
-			id = $7
+			id = $9 and version = $10
 	`
 	sqlDeleteLocks := `
 		delete from item_locks
@@ -104,44 +161,70 @@ func (r *repositoryPostgres) UpdateBulk(ctx context.Context, items []*inventory.
 	`
 
 	sqlInsertLock := `
-		insert into item_locks (item_id, locked_by, quantity)
-		values($1, $2, $3)
+		insert into item_locks (item_id, locked_by, quantity, locked_at, expires_at)
+		values($1, $2, $3, $4, $5)
 	`
 
 	for _, i := range items {
 		batch.Queue(sqlItems,
-			i.Name, i.Status, i.Description,
-			i.TotalQuantity, i.CreatedAt, i.UpdatedAt, i.ID,
+			i.OwnerID, i.Name, i.Status, i.Description,
+			i.TotalQuantity, i.CreatedAt, i.UpdatedAt, i.Sequence, i.ID, i.Version,
 		)
 
 		batch.Queue(sqlDeleteLocks, i.ID)
 		for _, l := range i.Locks {
-			batch.Queue(sqlInsertLock, i.ID, l.LockedBy, l.Quantity)
+			batch.Queue(sqlInsertLock, i.ID, l.LockedBy, l.Quantity, l.LockedAt, l.ExpiresAt)
 		}
-	}
 
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
+		if err := queueOutbox(ctx, batch, i); err != nil {
+			return err
+		}
 	}
 
-	res := tx.SendBatch(ctx, batch)
+	queueNotify(batch, items)
 
-	if _, err := res.Exec(); err != nil {
-		return err
-	}
+	err := r.transaction(ctx, func(tx pgx.Tx) error {
+		res := tx.SendBatch(ctx, batch)
+		defer res.Close()
 
-	res.Close()
+		for _, i := range items {
+			tag, err := res.Exec()
+			if err != nil {
+				return err
+			}
 
-	if err := tx.Commit(ctx); err != nil {
+			if tag.RowsAffected() != 1 {
+				return core.ErrVersionConflict
+			}
+
+			// locks delete, locks insert and outbox inserts
+			for n := 0; n < len(i.Locks)+len(i.Events())+1; n++ {
+				if _, err := res.Exec(); err != nil {
+					return err
+				}
+			}
+		}
+
+		return res.Close()
+	})
+
+	if err != nil {
 		return err
 	}
 
+	for _, i := range items {
+		i.Version++
+	}
+
+	clearEvents(items)
+
 	return nil
 }
 
 // DeleteBulk ...
-func (r *repositoryPostgres) DeleteBulk(ctx context.Context, ids []string) error {
+func (r *repositoryPostgres) DeleteBulk(ctx context.Context, items []*inventory.Item) error {
+
+	batch := &pgx.Batch{}
 
 	sqlDeleteLocks := `
 		delete from item_locks
@@ -155,23 +238,37 @@ func (r *repositoryPostgres) DeleteBulk(ctx context.Context, ids []string) error
 			id = any($1)
 	`
 
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
 	}
 
-	if _, err := tx.Exec(ctx, sqlDeleteLocks, ids); err != nil {
-		return err
-	}
+	batch.Queue(sqlDeleteLocks, ids)
+	batch.Queue(sqlDeleteItems, ids)
 
-	if _, err := tx.Exec(ctx, sqlDeleteItems, ids); err != nil {
-		return err
+	for _, i := range items {
+		// every deleted item publishes its deletion
+		if !i.IsDeleted() {
+			i.Delete()
+		}
+
+		if err := queueOutbox(ctx, batch, i); err != nil {
+			return err
+		}
 	}
 
-	if err := tx.Commit(ctx); err != nil {
+	queueNotify(batch, items)
+
+	err := r.transaction(ctx, func(tx pgx.Tx) error {
+		return sendBatch(ctx, tx, batch)
+	})
+
+	if err != nil {
 		return err
 	}
 
+	clearEvents(items)
+
 	return nil
 }
 
@@ -196,22 +293,357 @@ func (r *repositoryPostgres) Get(ctx context.Context, userID *string, ids []stri
 	return r.getItems(ctx, sql, args...)
 }
 
-// GetByStatus ...
-func (r *repositoryPostgres) GetByStatus(ctx context.Context, status inventory.ItemStatus) ([]*inventory.Item, error) {
+// GetForUpdate ...
+func (r *repositoryPostgres) GetForUpdate(ctx context.Context, userID *string, ids []string) ([]*inventory.Item, error) {
+
+	filter := "i.id = any($1)"
+	args := []interface{}{ids}
+
+	if userID != nil {
+		filter = filter + " and owner_id = $2"
+		args = append(args, *userID)
+	}
+
+	// rows are locked in id order so concurrent transactions
+	// wait on each other instead of deadlocking
+	sql := fmt.Sprintf(`
+		select * from items i
+			left join item_locks l on i.id = l.item_id
+		where
+			%s
+		order by i.id
+		for update of i
+	`, filter)
+
+	return r.getItems(ctx, sql, args...)
+}
+
+// GetWithExpiredLocks ...
+func (r *repositoryPostgres) GetWithExpiredLocks(ctx context.Context, until time.Time, limit int) ([]*inventory.Item, error) {
 
+	// the items are limited and locked before joining their locks
 	sql := `
 		select * from items i
 			left join item_locks l on i.id = l.item_id
-		where i.status = $1
+		where i.id in (
+			select id from items
+			where id in (
+				select item_id from item_locks
+				where expires_at <= $1
+			)
+			order by id
+			limit $2
+			for update skip locked
+		)
+		order by i.id
+	`
+
+	return r.getItems(ctx, sql, until, limit)
+}
+
+// GetByOwnerForUpdate ...
+func (r *repositoryPostgres) GetByOwnerForUpdate(ctx context.Context, ownerID string) ([]*inventory.Item, error) {
+
+	sql := `
+		select * from items i
+			left join item_locks l on i.id = l.item_id
+		where i.owner_id = $1
+		order by i.id
+		for update of i
+	`
+
+	return r.getItems(ctx, sql, ownerID)
+}
+
+// GetLockedByForUpdate ...
+func (r *repositoryPostgres) GetLockedByForUpdate(ctx context.Context, lockedBy string) ([]*inventory.Item, error) {
+
+	sql := `
+		select * from items i
+			left join item_locks l on i.id = l.item_id
+		where i.id in (
+			select item_id from item_locks
+			where locked_by = $1
+		)
+		order by i.id
+		for update of i
+	`
+
+	return r.getItems(ctx, sql, lockedBy)
+}
+
+// ListItems ...
+func (r *repositoryPostgres) ListItems(ctx context.Context, filter *inventory.ItemFilter, after string, limit int) ([]*inventory.Item, error) {
+
+	filters := []string{"id > $1"}
+	args := []interface{}{after}
+
+	if filter.OwnerID != "" {
+		args = append(args, filter.OwnerID)
+		filters = append(filters, fmt.Sprintf("owner_id = $%d", len(args)))
+	}
+
+	if len(filter.IDs) > 0 {
+		args = append(args, filter.IDs)
+		filters = append(filters, fmt.Sprintf("id = any($%d)", len(args)))
+	}
+
+	if filter.UpdatedSince != nil {
+		args = append(args, *filter.UpdatedSince)
+		filters = append(filters, fmt.Sprintf("updated_at >= $%d", len(args)))
+	}
+
+	args = append(args, limit)
+
+	// the page is limited before joining the locks,
+	// which return a row per lock
+	sql := fmt.Sprintf(`
+		select * from items i
+			left join item_locks l on i.id = l.item_id
+		where i.id in (
+			select id from items
+			where %s
+			order by id
+			limit $%d
+		)
+	`, strings.Join(filters, " and "), len(args))
+
+	items, err := r.getItems(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items, nil
+}
+
+// ClaimOutbox reserves up to limit pending outbox messages for workerID until
+// the lease ends. Rows being claimed by other workers are skipped, as well as
+// messages of items whose previous messages are still claimed by someone else.
+// Claims are serialized, as a claim not committed yet is not seen by the others,
+// which could then claim the following messages of the same items
+func (r *repositoryPostgres) ClaimOutbox(ctx context.Context, workerID string, limit int, lease time.Duration) ([]*inventory.OutboxMessage, error) {
+
+	sql := `
+		update outbox
+		set
+			claimed_by = $1,
+			claimed_until = now() + make_interval(secs => $3)
+		where id in (
+			select o.id
+			from outbox o
+			where
+				o.sent_at is null and
+				(o.claimed_until is null or o.claimed_until < now()) and
+				not exists (
+					select 1
+					from outbox p
+					where
+						p.aggregate_id = o.aggregate_id and
+						p.id < o.id and
+						p.sent_at is null and
+						p.claimed_until >= now()
+				)
+			order by o.id
+			limit $2
+			for update skip locked
+		)
+		returning id, aggregate_id, event_type, payload, coalesce(correlation_id, ''), created_at, sent_at
 	`
 
-	return r.getItems(ctx, sql, status)
+	var messages []*inventory.OutboxMessage
+
+	err := r.transaction(ctx, func(tx pgx.Tx) error {
+		// held until the claim commits, the claim waiting for it
+		// then sees the messages claimed by this one
+		if _, err := tx.Exec(ctx, "select pg_advisory_xact_lock(hashtext($1))", outboxClaimLock); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, sql, workerID, limit, lease.Seconds())
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			message := new(inventory.OutboxMessage)
+
+			err := rows.Scan(
+				&message.ID, &message.AggregateID, &message.EventType,
+				&message.Payload, &message.CorrelationID, &message.CreatedAt, &message.SentAt,
+			)
+
+			if err != nil {
+				return err
+			}
+
+			messages = append(messages, message)
+		}
+
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// returning does not keep the order of the subquery
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	return messages, nil
+}
+
+// ReleaseOutbox gives back the messages claimed by workerID that were not sent,
+// so other workers can claim them without waiting for the lease to end
+func (r *repositoryPostgres) ReleaseOutbox(ctx context.Context, workerID string, ids []int64) error {
+
+	sql := `
+		update outbox
+		set
+			claimed_by = null,
+			claimed_until = null
+		where
+			id = any($1) and
+			claimed_by = $2 and
+			sent_at is null
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, sql, ids, workerID)
+	return err
+}
+
+// MarkOutboxSent ...
+func (r *repositoryPostgres) MarkOutboxSent(ctx context.Context, ids []int64) error {
+
+	sql := `
+		update outbox
+		set
+			sent_at = now()
+		where
+			id = any($1)
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, sql, ids)
+	return err
+}
+
+// ListenOutbox holds a pool connection listening to the outbox channel until
+// ctx is done, the returned channel is closed when listening stops. A lost
+// connection is acquired again, backing off between attempts, and listens
+// again, notifying the channel once back as messages may have been missed
+func (r *repositoryPostgres) ListenOutbox(ctx context.Context) (<-chan struct{}, error) {
+	conn, err := r.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make(chan struct{}, 1)
+
+	go func() {
+		defer close(notifications)
+
+		for {
+			err := waitForNotifications(ctx, conn, notifications)
+			unlisten(conn)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			logrus.WithError(err).Error("lost outbox notifications connection, listening again")
+
+			if conn = r.listenAgain(ctx); conn == nil {
+				return
+			}
+
+			notify(notifications)
+		}
+	}()
+
+	return notifications, nil
+}
+
+// listen acquires a pool connection listening to the outbox channel
+func (r *repositoryPostgres) listen(ctx context.Context) (*pgxpool.Conn, error) {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(ctx, "listen "+outboxChannel); err != nil {
+		conn.Release()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// listenAgain retries listen with an exponential backoff until it succeeds,
+// returning nil when ctx is done first
+func (r *repositoryPostgres) listenAgain(ctx context.Context) *pgxpool.Conn {
+	backoff := listenMinBackoff
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		conn, err := r.listen(ctx)
+		if err == nil {
+			return conn
+		}
+
+		backoff = backoff * 2
+		if backoff > listenMaxBackoff {
+			backoff = listenMaxBackoff
+		}
+
+		logrus.WithError(err).WithField("backoff", backoff).
+			Error("unable to listen outbox notifications, retrying")
+	}
+}
+
+// waitForNotifications notifies notifications of every message written to the
+// outbox until the connection fails or ctx is done
+func waitForNotifications(ctx context.Context, conn *pgxpool.Conn, notifications chan<- struct{}) error {
+	for {
+		if _, err := conn.Conn().WaitForNotification(ctx); err != nil {
+			return err
+		}
+
+		notify(notifications)
+	}
+}
+
+// unlisten closes the connection before releasing it, so the pool
+// never hands out a connection still listening to the outbox channel
+func unlisten(conn *pgxpool.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn.Conn().Close(ctx)
+	conn.Release()
+}
+
+func notify(notifications chan<- struct{}) {
+	// a pending wake up already covers this notification
+	select {
+	case notifications <- struct{}{}:
+	default:
+	}
 }
 
 func (r *repositoryPostgres) getItems(ctx context.Context, sql string, args ...interface{}) ([]*inventory.Item, error) {
 	itemMap := map[string]*inventory.Item{}
 
-	rows, err := r.pool.Query(ctx, sql, args...)
+	rows, err := r.conn(ctx).Query(ctx, sql, args...)
 
 	if err != nil {
 		return nil, err
@@ -223,27 +655,35 @@ func (r *repositoryPostgres) getItems(ctx context.Context, sql string, args ...i
 		item := new(inventory.Item)
 		var itemID, lockedBy *string
 		var quantity *int64
+		var lockedAt, expiresAt *time.Time
 
 		err := rows.Scan(
 			&item.ID, &item.OwnerID, &item.Name, &item.Status,
 			&item.Description, &item.TotalQuantity,
-			&item.CreatedAt, &item.UpdatedAt,
+			&item.CreatedAt, &item.UpdatedAt, &item.Version, &item.Sequence,
 
-			&itemID, &lockedBy, &quantity,
+			&itemID, &lockedBy, &quantity, &lockedAt, &expiresAt,
 		)
 
 		if err != nil {
 			return nil, err
 		}
 
-		if i, exist := itemMap[item.ID]; exist {
-			i.Locks = append(i.Locks, &inventory.ItemLock{LockedBy: *lockedBy, Quantity: inventory.ItemQuantity(*quantity)})
-		} else {
-			if itemID != nil {
-				item.Locks = append(item.Locks, &inventory.ItemLock{LockedBy: *lockedBy, Quantity: inventory.ItemQuantity(*quantity)})
-			}
+		i, exist := itemMap[item.ID]
+		if !exist {
+			i = item
+			i.MarkPersisted()
 			itemMap[item.ID] = item
 		}
+
+		if itemID != nil {
+			i.Locks = append(i.Locks, &inventory.ItemLock{
+				LockedBy:  *lockedBy,
+				Quantity:  inventory.ItemQuantity(*quantity),
+				LockedAt:  *lockedAt,
+				ExpiresAt: expiresAt,
+			})
+		}
 	}
 
 	if err := rows.Err(); err != nil {
@@ -257,3 +697,99 @@ func (r *repositoryPostgres) getItems(ctx context.Context, sql string, args ...i
 
 	return items, nil
 }
+
+// conn returns the transaction bound to ctx by WithTransaction, or the pool
+func (r *repositoryPostgres) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+
+	return r.pool
+}
+
+// transaction runs fn in a new transaction, or in a savepoint
+// when ctx is already bound to one
+func (r *repositoryPostgres) transaction(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := r.conn(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return mapError(err)
+	}
+
+	return mapError(tx.Commit(ctx))
+}
+
+// queueOutbox queues the events recorded by the item, so they
+// are written in the same transaction as the item itself
+func queueOutbox(ctx context.Context, batch *pgx.Batch, item *inventory.Item) error {
+	sql := `
+		insert into
+		outbox(aggregate_id, event_type, payload, correlation_id, created_at)
+		values($1, $2, $3, nullif($4, ''), $5)
+	`
+
+	correlationID := core.CorrelationIDFromContext(ctx)
+
+	for _, event := range item.Events() {
+		message, err := inventory.NewOutboxMessage(event)
+		if err != nil {
+			return err
+		}
+
+		batch.Queue(sql, message.AggregateID, message.EventType, message.Payload, correlationID, message.CreatedAt)
+	}
+
+	return nil
+}
+
+// queueNotify wakes up the outbox listeners once the transaction
+// commits, postgres delivers nothing when it rolls back
+func queueNotify(batch *pgx.Batch, items []*inventory.Item) {
+	for _, item := range items {
+		if len(item.Events()) > 0 {
+			batch.Queue("select pg_notify($1, '')", outboxChannel)
+			return
+		}
+	}
+}
+
+func clearEvents(items []*inventory.Item) {
+	for _, item := range items {
+		item.ClearEvents()
+		item.MarkPersisted()
+	}
+}
+
+func sendBatch(ctx context.Context, tx pgx.Tx, batch *pgx.Batch) error {
+	res := tx.SendBatch(ctx, batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := res.Exec(); err != nil {
+			res.Close()
+			return err
+		}
+	}
+
+	return res.Close()
+}
+
+// mapError translates database constraint violations into application errors
+func mapError(err error) error {
+	var pgErr *pgconn.PgError
+
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.ConstraintName {
+	case "item_locks_quantity_check":
+		return core.ErrNotEnoughtItemsToLock
+	}
+
+	return err
+}
@@ -0,0 +1,247 @@
+package postgres_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory/postgres"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// repositoryTestSuite runs against the database at POSTGRES_URL,
+// with every migration applied
+type repositoryTestSuite struct {
+	suite.Suite
+	assert     *assert.Assertions
+	ctx        context.Context
+	pool       *pgxpool.Pool
+	repository inventory.Repository
+}
+
+func TestRepositoryTestSuite(t *testing.T) {
+	suite.Run(t, new(repositoryTestSuite))
+}
+
+func (s *repositoryTestSuite) SetupSuite() {
+	s.assert = assert.New(s.T())
+	s.ctx = context.Background()
+
+	url := os.Getenv("POSTGRES_URL")
+	if url == "" {
+		s.T().Skip("POSTGRES_URL is not set")
+	}
+
+	pool, err := pgxpool.Connect(s.ctx, url)
+	s.Require().NoError(err)
+
+	s.pool = pool
+	s.repository = postgres.NewRepository(pool)
+}
+
+func (s *repositoryTestSuite) TearDownSuite() {
+	if s.pool != nil {
+		s.pool.Close()
+	}
+}
+
+func (s *repositoryTestSuite) TestAnonymize() {
+	ownerID := uuid.NewString()
+	description := "my old bike"
+
+	item, err := inventory.NewItem(uuid.NewString(), ownerID, "bike", &description, 2, inventory.ItemAvailable)
+	s.Require().NoError(err)
+	s.Require().NoError(item.Lock(uuid.NewString(), 1, 0))
+	s.Require().NoError(s.repository.InsertBulk(s.ctx, []*inventory.Item{item}))
+
+	err = s.repository.WithTransaction(s.ctx, func(ctx context.Context) error {
+		items, err := s.repository.GetByOwnerForUpdate(ctx, ownerID)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			item.ReleaseLocks(inventory.ItemUnlockOwnerDeleted)
+			item.Anonymize()
+		}
+
+		return s.repository.UpdateBulk(ctx, items)
+	})
+	s.Require().NoError(err)
+
+	items, err := s.repository.Get(s.ctx, nil, []string{item.ID})
+	s.Require().NoError(err)
+	s.Require().Len(items, 1)
+
+	s.assert.Equal(inventory.AnonymizedOwnerID, items[0].OwnerID)
+	s.assert.Equal(inventory.AnonymizedItemName, items[0].Name)
+	s.assert.Equal(inventory.ItemAnonymized, items[0].Status)
+	s.assert.Equal(inventory.AnonymizedItemDescription, *items[0].Description)
+	s.assert.Empty(items[0].Locks)
+
+	items, err = s.repository.GetByOwnerForUpdate(s.ctx, ownerID)
+	s.assert.NoError(err)
+	s.assert.Empty(items)
+}
+
+func (s *repositoryTestSuite) TestDeleteBulkWritesDeletion() {
+	description := "my old bike"
+
+	item, err := inventory.NewItem(uuid.NewString(), uuid.NewString(), "bike", &description, 2, inventory.ItemAvailable)
+	s.Require().NoError(err)
+	s.Require().NoError(s.repository.InsertBulk(s.ctx, []*inventory.Item{item}))
+
+	// the deletion is written even when the item did not record it
+	s.Require().NoError(s.repository.DeleteBulk(s.ctx, []*inventory.Item{item}))
+
+	var count int
+	err = s.pool.QueryRow(s.ctx, `
+		select count(*) from outbox
+		where aggregate_id = $1 and event_type = $2
+	`, item.ID, inventory.ItemDeletedEventType).Scan(&count)
+
+	s.assert.NoError(err)
+	s.assert.Equal(1, count)
+
+	items, err := s.repository.Get(s.ctx, nil, []string{item.ID})
+	s.assert.NoError(err)
+	s.assert.Empty(items)
+}
+
+func (s *repositoryTestSuite) TestListenOutboxAfterConnectionLost() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	notifications, err := s.repository.ListenOutbox(ctx)
+	s.Require().NoError(err)
+
+	// terminates the backend of the listening connection
+	_, err = s.pool.Exec(s.ctx, `
+		select pg_terminate_backend(pid)
+		from pg_stat_activity
+		where query = 'listen outbox' and pid <> pg_backend_pid()
+	`)
+	s.Require().NoError(err)
+
+	// the connection is acquired again and the channel notified once listening
+	select {
+	case _, ok := <-notifications:
+		s.Require().True(ok)
+	case <-time.After(5 * time.Second):
+		s.FailNow("outbox was not listened again")
+	}
+
+	description := "my old bike"
+
+	item, err := inventory.NewItem(uuid.NewString(), uuid.NewString(), "bike", &description, 2, inventory.ItemAvailable)
+	s.Require().NoError(err)
+	s.Require().NoError(s.repository.InsertBulk(s.ctx, []*inventory.Item{item}))
+
+	select {
+	case _, ok := <-notifications:
+		s.assert.True(ok)
+	case <-time.After(5 * time.Second):
+		s.Fail("new outbox message was not notified")
+	}
+
+	cancel()
+
+	s.assert.Eventually(func() bool {
+		_, ok := <-notifications
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *repositoryTestSuite) TestGetWithExpiredLocksLimit() {
+	description := "my old bike"
+	var items []*inventory.Item
+
+	for i := 0; i < 3; i++ {
+		item, err := inventory.NewItem(uuid.NewString(), uuid.NewString(), "bike", &description, 2, inventory.ItemAvailable)
+		s.Require().NoError(err)
+		s.Require().NoError(item.Lock(uuid.NewString(), 1, time.Nanosecond))
+
+		items = append(items, item)
+	}
+
+	s.Require().NoError(s.repository.InsertBulk(s.ctx, items))
+
+	err := s.repository.WithTransaction(s.ctx, func(ctx context.Context) error {
+		expired, err := s.repository.GetWithExpiredLocks(ctx, time.Now(), 2)
+		if err != nil {
+			return err
+		}
+
+		s.assert.Len(expired, 2)
+
+		for _, item := range expired {
+			s.assert.NotEmpty(item.Locks)
+		}
+
+		return nil
+	})
+
+	s.assert.NoError(err)
+}
+
+func (s *repositoryTestSuite) TestClaimOutboxConcurrently() {
+	// the messages left by previous runs are sent first
+	pending, err := s.repository.ClaimOutbox(s.ctx, uuid.NewString(), 100000, time.Minute)
+	s.Require().NoError(err)
+
+	ids := make([]int64, len(pending))
+	for i, message := range pending {
+		ids[i] = message.ID
+	}
+
+	s.Require().NoError(s.repository.MarkOutboxSent(s.ctx, ids))
+
+	description := "my old bike"
+
+	item, err := inventory.NewItem(uuid.NewString(), uuid.NewString(), "bike", &description, 2, inventory.ItemAvailable)
+	s.Require().NoError(err)
+	s.Require().NoError(item.Lock(uuid.NewString(), 1, 0))
+	s.Require().NoError(s.repository.InsertBulk(s.ctx, []*inventory.Item{item}))
+
+	claimed := make(chan []*inventory.OutboxMessage, 1)
+	committed := make(chan struct{})
+
+	err = s.repository.WithTransaction(s.ctx, func(ctx context.Context) error {
+		first, err := s.repository.ClaimOutbox(ctx, "first", 1, time.Minute)
+		if err != nil {
+			return err
+		}
+
+		s.assert.Len(first, 1)
+		s.assert.Equal(inventory.ItemCreatedEventType, first[0].EventType)
+
+		// the second claim starts while the first one is not committed
+		go func() {
+			second, err := s.repository.ClaimOutbox(s.ctx, "second", 10, time.Minute)
+			s.assert.NoError(err)
+
+			<-committed
+			claimed <- second
+		}()
+
+		time.Sleep(200 * time.Millisecond)
+
+		return nil
+	})
+
+	close(committed)
+	s.Require().NoError(err)
+
+	select {
+	case second := <-claimed:
+		// the lock event waits for the created event claimed by the first
+		s.assert.Empty(second)
+	case <-time.After(5 * time.Second):
+		s.Fail("second claim did not return")
+	}
+}
@@ -123,6 +123,7 @@ type LockItemsRequest struct {
 	WantedItemsOwnerID string        `protobuf:"bytes,3,opt,name=wantedItemsOwnerID,proto3" json:"wantedItemsOwnerID,omitempty"`
 	OfferedItems       []*ItemToLock `protobuf:"bytes,4,rep,name=offeredItems,proto3" json:"offeredItems,omitempty"`
 	WantedItems        []*ItemToLock `protobuf:"bytes,5,rep,name=wantedItems,proto3" json:"wantedItems,omitempty"`
+	TtlSeconds         int64         `protobuf:"varint,6,opt,name=ttlSeconds,proto3" json:"ttlSeconds,omitempty"`
 }
 
 func (x *LockItemsRequest) Reset() {
@@ -192,6 +193,76 @@ func (x *LockItemsRequest) GetWantedItems() []*ItemToLock {
 	return nil
 }
 
+func (x *LockItemsRequest) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+type UnlockItemsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LockedBy string   `protobuf:"bytes,1,opt,name=lockedBy,proto3" json:"lockedBy,omitempty"`
+	OwnerID  string   `protobuf:"bytes,2,opt,name=ownerID,proto3" json:"ownerID,omitempty"`
+	ItemIDs  []string `protobuf:"bytes,3,rep,name=itemIDs,proto3" json:"itemIDs,omitempty"`
+}
+
+func (x *UnlockItemsRequest) Reset() {
+	*x = UnlockItemsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_inventory_proto_service_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UnlockItemsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnlockItemsRequest) ProtoMessage() {}
+
+func (x *UnlockItemsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_inventory_proto_service_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnlockItemsRequest.ProtoReflect.Descriptor instead.
+func (*UnlockItemsRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_inventory_proto_service_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UnlockItemsRequest) GetLockedBy() string {
+	if x != nil {
+		return x.LockedBy
+	}
+	return ""
+}
+
+func (x *UnlockItemsRequest) GetOwnerID() string {
+	if x != nil {
+		return x.OwnerID
+	}
+	return ""
+}
+
+func (x *UnlockItemsRequest) GetItemIDs() []string {
+	if x != nil {
+		return x.ItemIDs
+	}
+	return nil
+}
+
 type ItemToTrade struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -204,7 +275,7 @@ type ItemToTrade struct {
 func (x *ItemToTrade) Reset() {
 	*x = ItemToTrade{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_pkg_inventory_proto_service_proto_msgTypes[3]
+		mi := &file_pkg_inventory_proto_service_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -217,7 +288,7 @@ func (x *ItemToTrade) String() string {
 func (*ItemToTrade) ProtoMessage() {}
 
 func (x *ItemToTrade) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_inventory_proto_service_proto_msgTypes[3]
+	mi := &file_pkg_inventory_proto_service_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -230,7 +301,7 @@ func (x *ItemToTrade) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ItemToTrade.ProtoReflect.Descriptor instead.
 func (*ItemToTrade) Descriptor() ([]byte, []int) {
-	return file_pkg_inventory_proto_service_proto_rawDescGZIP(), []int{3}
+	return file_pkg_inventory_proto_service_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *ItemToTrade) GetId() string {
@@ -262,7 +333,7 @@ type TradeItemsRequest struct {
 func (x *TradeItemsRequest) Reset() {
 	*x = TradeItemsRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_pkg_inventory_proto_service_proto_msgTypes[4]
+		mi := &file_pkg_inventory_proto_service_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -275,7 +346,7 @@ func (x *TradeItemsRequest) String() string {
 func (*TradeItemsRequest) ProtoMessage() {}
 
 func (x *TradeItemsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_inventory_proto_service_proto_msgTypes[4]
+	mi := &file_pkg_inventory_proto_service_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -288,7 +359,7 @@ func (x *TradeItemsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TradeItemsRequest.ProtoReflect.Descriptor instead.
 func (*TradeItemsRequest) Descriptor() ([]byte, []int) {
-	return file_pkg_inventory_proto_service_proto_rawDescGZIP(), []int{4}
+	return file_pkg_inventory_proto_service_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *TradeItemsRequest) GetTradeID() string {
@@ -336,7 +407,7 @@ var file_pkg_inventory_proto_service_proto_rawDesc = []byte{
 	0x6f, 0x4c, 0x6f, 0x63, 0x6b, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
 	0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x71, 0x75, 0x61, 0x6e, 0x74, 0x69, 0x74,
 	0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x71, 0x75, 0x61, 0x6e, 0x74, 0x69, 0x74,
-	0x79, 0x22, 0xec, 0x01, 0x0a, 0x10, 0x4c, 0x6f, 0x63, 0x6b, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52,
+	0x79, 0x22, 0x8c, 0x02, 0x0a, 0x10, 0x4c, 0x6f, 0x63, 0x6b, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52,
 	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x6f, 0x63, 0x6b, 0x65, 0x64,
 	0x42, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x6f, 0x63, 0x6b, 0x65, 0x64,
 	0x42, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x18, 0x02, 0x20,
@@ -351,36 +422,49 @@ var file_pkg_inventory_proto_service_proto_rawDesc = []byte{
 	0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x69,
 	0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x54, 0x6f, 0x4c,
 	0x6f, 0x63, 0x6b, 0x52, 0x0b, 0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73,
-	0x22, 0x39, 0x0a, 0x0b, 0x49, 0x74, 0x65, 0x6d, 0x54, 0x6f, 0x54, 0x72, 0x61, 0x64, 0x65, 0x12,
-	0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12,
-	0x1a, 0x0a, 0x08, 0x71, 0x75, 0x61, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x08, 0x71, 0x75, 0x61, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x22, 0xed, 0x01, 0x0a, 0x11,
-	0x54, 0x72, 0x61, 0x64, 0x65, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x12, 0x18, 0x0a, 0x07, 0x74, 0x72, 0x61, 0x64, 0x65, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x07, 0x74, 0x72, 0x61, 0x64, 0x65, 0x49, 0x44, 0x12, 0x18, 0x0a, 0x07, 0x6f,
-	0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6f, 0x77,
-	0x6e, 0x65, 0x72, 0x49, 0x44, 0x12, 0x2e, 0x0a, 0x12, 0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49,
-	0x74, 0x65, 0x6d, 0x73, 0x4f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x18, 0x03, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x12, 0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x4f, 0x77,
-	0x6e, 0x65, 0x72, 0x49, 0x44, 0x12, 0x3a, 0x0a, 0x0c, 0x6f, 0x66, 0x66, 0x65, 0x72, 0x65, 0x64,
-	0x49, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x69, 0x6e,
-	0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x54, 0x6f, 0x54, 0x72,
-	0x61, 0x64, 0x65, 0x52, 0x0c, 0x6f, 0x66, 0x66, 0x65, 0x72, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d,
-	0x73, 0x12, 0x38, 0x0a, 0x0b, 0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73,
-	0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f,
-	0x72, 0x79, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x54, 0x6f, 0x54, 0x72, 0x61, 0x64, 0x65, 0x52, 0x0b,
-	0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x32, 0x90, 0x01, 0x0a, 0x10,
-	0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
-	0x12, 0x3c, 0x0a, 0x09, 0x4c, 0x6f, 0x63, 0x6b, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x12, 0x1b, 0x2e,
-	0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x4c, 0x6f, 0x63, 0x6b, 0x49, 0x74,
-	0x65, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x69, 0x6e, 0x76,
-	0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x3e,
-	0x0a, 0x0a, 0x54, 0x72, 0x61, 0x64, 0x65, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x12, 0x1c, 0x2e, 0x69,
-	0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x54, 0x72, 0x61, 0x64, 0x65, 0x49, 0x74,
-	0x65, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x69, 0x6e, 0x76,
-	0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x42, 0x15,
-	0x5a, 0x13, 0x70, 0x6b, 0x67, 0x2f, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2f,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x12, 0x1e, 0x0a, 0x0a, 0x74, 0x74, 0x6c, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x74, 0x74, 0x6c, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73,
+	0x22, 0x64, 0x0a, 0x12, 0x55, 0x6e, 0x6c, 0x6f, 0x63, 0x6b, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x6f, 0x63, 0x6b, 0x65, 0x64,
+	0x42, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x6f, 0x63, 0x6b, 0x65, 0x64,
+	0x42, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x12, 0x18, 0x0a, 0x07,
+	0x69, 0x74, 0x65, 0x6d, 0x49, 0x44, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x69,
+	0x74, 0x65, 0x6d, 0x49, 0x44, 0x73, 0x22, 0x39, 0x0a, 0x0b, 0x49, 0x74, 0x65, 0x6d, 0x54, 0x6f,
+	0x54, 0x72, 0x61, 0x64, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x71, 0x75, 0x61, 0x6e, 0x74, 0x69, 0x74,
+	0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x71, 0x75, 0x61, 0x6e, 0x74, 0x69, 0x74,
+	0x79, 0x22, 0xed, 0x01, 0x0a, 0x11, 0x54, 0x72, 0x61, 0x64, 0x65, 0x49, 0x74, 0x65, 0x6d, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x74, 0x72, 0x61, 0x64, 0x65,
+	0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x74, 0x72, 0x61, 0x64, 0x65, 0x49,
+	0x44, 0x12, 0x18, 0x0a, 0x07, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x07, 0x6f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x12, 0x2e, 0x0a, 0x12, 0x77,
+	0x61, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x4f, 0x77, 0x6e, 0x65, 0x72, 0x49,
+	0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49,
+	0x74, 0x65, 0x6d, 0x73, 0x4f, 0x77, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x12, 0x3a, 0x0a, 0x0c, 0x6f,
+	0x66, 0x66, 0x65, 0x72, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x16, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x49, 0x74,
+	0x65, 0x6d, 0x54, 0x6f, 0x54, 0x72, 0x61, 0x64, 0x65, 0x52, 0x0c, 0x6f, 0x66, 0x66, 0x65, 0x72,
+	0x65, 0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x12, 0x38, 0x0a, 0x0b, 0x77, 0x61, 0x6e, 0x74, 0x65,
+	0x64, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x69,
+	0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x49, 0x74, 0x65, 0x6d, 0x54, 0x6f, 0x54,
+	0x72, 0x61, 0x64, 0x65, 0x52, 0x0b, 0x77, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x74, 0x65, 0x6d,
+	0x73, 0x32, 0xd2, 0x01, 0x0a, 0x10, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x53,
+	0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x3c, 0x0a, 0x09, 0x4c, 0x6f, 0x63, 0x6b, 0x49, 0x74,
+	0x65, 0x6d, 0x73, 0x12, 0x1b, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e,
+	0x4c, 0x6f, 0x63, 0x6b, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x10, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x22, 0x00, 0x12, 0x40, 0x0a, 0x0b, 0x55, 0x6e, 0x6c, 0x6f, 0x63, 0x6b, 0x49, 0x74,
+	0x65, 0x6d, 0x73, 0x12, 0x1d, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e,
+	0x55, 0x6e, 0x6c, 0x6f, 0x63, 0x6b, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x10, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x45,
+	0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x3e, 0x0a, 0x0a, 0x54, 0x72, 0x61, 0x64, 0x65, 0x49,
+	0x74, 0x65, 0x6d, 0x73, 0x12, 0x1c, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79,
+	0x2e, 0x54, 0x72, 0x61, 0x64, 0x65, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x10, 0x2e, 0x69, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2e, 0x45,
+	0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x42, 0x15, 0x5a, 0x13, 0x70, 0x6b, 0x67, 0x2f, 0x69, 0x6e,
+	0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -395,25 +479,28 @@ func file_pkg_inventory_proto_service_proto_rawDescGZIP() []byte {
 	return file_pkg_inventory_proto_service_proto_rawDescData
 }
 
-var file_pkg_inventory_proto_service_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_pkg_inventory_proto_service_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_pkg_inventory_proto_service_proto_goTypes = []interface{}{
-	(*Empty)(nil),             // 0: inventory.Empty
-	(*ItemToLock)(nil),        // 1: inventory.ItemToLock
-	(*LockItemsRequest)(nil),  // 2: inventory.LockItemsRequest
-	(*ItemToTrade)(nil),       // 3: inventory.ItemToTrade
-	(*TradeItemsRequest)(nil), // 4: inventory.TradeItemsRequest
+	(*Empty)(nil),              // 0: inventory.Empty
+	(*ItemToLock)(nil),         // 1: inventory.ItemToLock
+	(*LockItemsRequest)(nil),   // 2: inventory.LockItemsRequest
+	(*UnlockItemsRequest)(nil), // 3: inventory.UnlockItemsRequest
+	(*ItemToTrade)(nil),        // 4: inventory.ItemToTrade
+	(*TradeItemsRequest)(nil),  // 5: inventory.TradeItemsRequest
 }
 var file_pkg_inventory_proto_service_proto_depIdxs = []int32{
 	1, // 0: inventory.LockItemsRequest.offeredItems:type_name -> inventory.ItemToLock
 	1, // 1: inventory.LockItemsRequest.wantedItems:type_name -> inventory.ItemToLock
-	3, // 2: inventory.TradeItemsRequest.offeredItems:type_name -> inventory.ItemToTrade
-	3, // 3: inventory.TradeItemsRequest.wantedItems:type_name -> inventory.ItemToTrade
+	4, // 2: inventory.TradeItemsRequest.offeredItems:type_name -> inventory.ItemToTrade
+	4, // 3: inventory.TradeItemsRequest.wantedItems:type_name -> inventory.ItemToTrade
 	2, // 4: inventory.InventoryService.LockItems:input_type -> inventory.LockItemsRequest
-	4, // 5: inventory.InventoryService.TradeItems:input_type -> inventory.TradeItemsRequest
-	0, // 6: inventory.InventoryService.LockItems:output_type -> inventory.Empty
-	0, // 7: inventory.InventoryService.TradeItems:output_type -> inventory.Empty
-	6, // [6:8] is the sub-list for method output_type
-	4, // [4:6] is the sub-list for method input_type
+	3, // 5: inventory.InventoryService.UnlockItems:input_type -> inventory.UnlockItemsRequest
+	5, // 6: inventory.InventoryService.TradeItems:input_type -> inventory.TradeItemsRequest
+	0, // 7: inventory.InventoryService.LockItems:output_type -> inventory.Empty
+	0, // 8: inventory.InventoryService.UnlockItems:output_type -> inventory.Empty
+	0, // 9: inventory.InventoryService.TradeItems:output_type -> inventory.Empty
+	7, // [7:10] is the sub-list for method output_type
+	4, // [4:7] is the sub-list for method input_type
 	4, // [4:4] is the sub-list for extension type_name
 	4, // [4:4] is the sub-list for extension extendee
 	0, // [0:4] is the sub-list for field type_name
@@ -462,7 +549,7 @@ func file_pkg_inventory_proto_service_proto_init() {
 			}
 		}
 		file_pkg_inventory_proto_service_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ItemToTrade); i {
+			switch v := v.(*UnlockItemsRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -474,6 +561,18 @@ func file_pkg_inventory_proto_service_proto_init() {
 			}
 		}
 		file_pkg_inventory_proto_service_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ItemToTrade); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_inventory_proto_service_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*TradeItemsRequest); i {
 			case 0:
 				return &v.state
@@ -492,7 +591,7 @@ func file_pkg_inventory_proto_service_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_pkg_inventory_proto_service_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
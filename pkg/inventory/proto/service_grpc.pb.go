@@ -19,6 +19,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type InventoryServiceClient interface {
 	LockItems(ctx context.Context, in *LockItemsRequest, opts ...grpc.CallOption) (*Empty, error)
+	UnlockItems(ctx context.Context, in *UnlockItemsRequest, opts ...grpc.CallOption) (*Empty, error)
 	TradeItems(ctx context.Context, in *TradeItemsRequest, opts ...grpc.CallOption) (*Empty, error)
 }
 
@@ -39,6 +40,15 @@ func (c *inventoryServiceClient) LockItems(ctx context.Context, in *LockItemsReq
 	return out, nil
 }
 
+func (c *inventoryServiceClient) UnlockItems(ctx context.Context, in *UnlockItemsRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/inventory.InventoryService/UnlockItems", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *inventoryServiceClient) TradeItems(ctx context.Context, in *TradeItemsRequest, opts ...grpc.CallOption) (*Empty, error) {
 	out := new(Empty)
 	err := c.cc.Invoke(ctx, "/inventory.InventoryService/TradeItems", in, out, opts...)
@@ -53,6 +63,7 @@ func (c *inventoryServiceClient) TradeItems(ctx context.Context, in *TradeItemsR
 // for forward compatibility
 type InventoryServiceServer interface {
 	LockItems(context.Context, *LockItemsRequest) (*Empty, error)
+	UnlockItems(context.Context, *UnlockItemsRequest) (*Empty, error)
 	TradeItems(context.Context, *TradeItemsRequest) (*Empty, error)
 	mustEmbedUnimplementedInventoryServiceServer()
 }
@@ -64,6 +75,9 @@ type UnimplementedInventoryServiceServer struct {
 func (UnimplementedInventoryServiceServer) LockItems(context.Context, *LockItemsRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method LockItems not implemented")
 }
+func (UnimplementedInventoryServiceServer) UnlockItems(context.Context, *UnlockItemsRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnlockItems not implemented")
+}
 func (UnimplementedInventoryServiceServer) TradeItems(context.Context, *TradeItemsRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method TradeItems not implemented")
 }
@@ -98,6 +112,24 @@ func _InventoryService_LockItems_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _InventoryService_UnlockItems_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockItemsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InventoryServiceServer).UnlockItems(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inventory.InventoryService/UnlockItems",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InventoryServiceServer).UnlockItems(ctx, req.(*UnlockItemsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _InventoryService_TradeItems_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(TradeItemsRequest)
 	if err := dec(in); err != nil {
@@ -127,6 +159,10 @@ var InventoryService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "LockItems",
 			Handler:    _InventoryService_LockItems_Handler,
 		},
+		{
+			MethodName: "UnlockItems",
+			Handler:    _InventoryService_UnlockItems_Handler,
+		},
 		{
 			MethodName: "TradeItems",
 			Handler:    _InventoryService_TradeItems_Handler,
@@ -0,0 +1,146 @@
+package inventory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/d-leme/tradew-inventory-write/pkg/core"
+	"github.com/d-leme/tradew-inventory-write/pkg/inventory/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	// EventSource is the source of every cloud event published by the service
+	EventSource = "inventory-write"
+
+	// EventTypePrefix prefixes the event types in cloud events
+	EventTypePrefix = "tradew.inventory."
+)
+
+// eventSchemaVersions holds the current version of the payload of each event
+// type. A breaking change to a payload bumps its version, the struct of the
+// previous version is then kept in eventSchemas to decode published events
+var eventSchemaVersions = map[EventType]int{
+	ItemCreatedEventType:     1,
+	ItemUpdatedEventType:     1,
+	ItemLockedEventType:      1,
+	ItemUnlockedEventType:    1,
+	ItemTransferredEventType: 1,
+	ItemDeletedEventType:     1,
+	ItemsUpdatedEventType:    1,
+	ItemsDeletedEventType:    1,
+}
+
+// eventSchemas maps every schema to a constructor of the struct decoding it
+var eventSchemas = map[string]func() interface{}{
+	EventSchema(ItemCreatedEventType, 1):     func() interface{} { return new(ItemCreatedEvent) },
+	EventSchema(ItemUpdatedEventType, 1):     func() interface{} { return new(ItemUpdatedEvent) },
+	EventSchema(ItemLockedEventType, 1):      func() interface{} { return new(ItemLockedEvent) },
+	EventSchema(ItemUnlockedEventType, 1):    func() interface{} { return new(ItemUnlockedEvent) },
+	EventSchema(ItemTransferredEventType, 1): func() interface{} { return new(ItemTransferredEvent) },
+	EventSchema(ItemDeletedEventType, 1):     func() interface{} { return new(ItemDeletedEvent) },
+	EventSchema(ItemsUpdatedEventType, 1):    func() interface{} { return new(ItemsUpdatedEvent) },
+	EventSchema(ItemsDeletedEventType, 1):    func() interface{} { return new(ItemsDeletedEvent) },
+}
+
+// eventProtos maps every schema to a constructor of the generated protobuf
+// message decoding it, when published with a protobuf encoding
+var eventProtos = map[string]func() protobuf.Message{
+	EventSchema(ItemCreatedEventType, 1):     func() protobuf.Message { return new(proto.ItemUpdatedEvent) },
+	EventSchema(ItemUpdatedEventType, 1):     func() protobuf.Message { return new(proto.ItemUpdatedEvent) },
+	EventSchema(ItemLockedEventType, 1):      func() protobuf.Message { return new(proto.ItemLockedEvent) },
+	EventSchema(ItemUnlockedEventType, 1):    func() protobuf.Message { return new(proto.ItemUnlockedEvent) },
+	EventSchema(ItemTransferredEventType, 1): func() protobuf.Message { return new(proto.ItemTransferredEvent) },
+	EventSchema(ItemDeletedEventType, 1):     func() protobuf.Message { return new(proto.ItemDeletedEvent) },
+	EventSchema(ItemsUpdatedEventType, 1):    func() protobuf.Message { return new(proto.ItemsUpdatedEvent) },
+	EventSchema(ItemsDeletedEventType, 1):    func() protobuf.Message { return new(proto.ItemsDeletedEvent) },
+}
+
+// EventSchema returns the dataschema of the given version of an event type
+func EventSchema(eventType EventType, version int) string {
+	return fmt.Sprintf("urn:tradew:inventory:%s:v%d", eventType, version)
+}
+
+// NewCloudEvent wraps data, the payload of an event of eventType, in a cloud
+// event with the current schema of the event type. Payloads given as json are
+// decoded into their struct, so publishers can encode them with protobuf
+func NewCloudEvent(id string, eventType EventType, subject string, at time.Time, data interface{}) (*core.CloudEvent, error) {
+	schema := EventSchema(eventType, eventSchemaVersions[eventType])
+	payload, ok := data.(json.RawMessage)
+
+	if ok {
+		if newData, found := eventSchemas[schema]; found {
+			data = newData()
+			if err := json.Unmarshal(payload, data); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		var err error
+		if payload, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return &core.CloudEvent{
+		SpecVersion:     core.CloudEventsSpecVersion,
+		ID:              id,
+		Source:          EventSource,
+		Type:            EventTypePrefix + string(eventType),
+		Subject:         subject,
+		Time:            at.UTC(),
+		DataContentType: core.ContentTypeJSON,
+		DataSchema:      schema,
+		Data:            payload,
+		Payload:         data,
+	}, nil
+}
+
+// DecodeCloudEvent returns the event type of the cloud event and its data
+// decoded into the struct of its schema version, data encoded with protobuf
+// is decoded into the generated message of its schema version instead
+func DecodeCloudEvent(event *core.CloudEvent) (EventType, interface{}, error) {
+	eventType := EventType(strings.TrimPrefix(event.Type, EventTypePrefix))
+
+	switch event.DataContentType {
+	case core.ContentTypeProtobuf, core.ContentTypeProtoJSON:
+		data, err := decodeProtoData(event)
+		return eventType, data, err
+	}
+
+	newData, ok := eventSchemas[event.DataSchema]
+	if !ok {
+		return eventType, nil, core.ErrValidationFailed
+	}
+
+	data := newData()
+	if err := json.Unmarshal(event.Data, data); err != nil {
+		return eventType, nil, err
+	}
+
+	return eventType, data, nil
+}
+
+func decodeProtoData(event *core.CloudEvent) (protobuf.Message, error) {
+	newData, ok := eventProtos[event.DataSchema]
+	if !ok {
+		return nil, core.ErrValidationFailed
+	}
+
+	data := newData()
+
+	if event.DataContentType == core.ContentTypeProtoJSON {
+		return data, protojson.Unmarshal(event.Data, data)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(event.DataBase64)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, protobuf.Unmarshal(body, data)
+}
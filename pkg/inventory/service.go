@@ -2,6 +2,7 @@ package inventory
 
 import (
 	"context"
+	"time"
 
 	"github.com/d-leme/tradew-inventory-write/pkg/core"
 	"github.com/google/uuid"
@@ -10,14 +11,45 @@ import (
 )
 
 type service struct {
-	repository Repository
-	pool       *pgxpool.Pool
+	repository     Repository
+	pool           *pgxpool.Pool
+	defaultLockTTL time.Duration
+	releaseBatch   int
 }
 
+const defaultReleaseBatchSize = 100
+
+// ServiceOption ...
+type ServiceOption func(*service)
+
 // NewService ...
-func NewService(repository Repository) Service {
-	return &service{
-		repository: repository,
+func NewService(repository Repository, opts ...ServiceOption) Service {
+	s := &service{
+		repository:   repository,
+		releaseBatch: defaultReleaseBatchSize,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithDefaultLockTTL sets the ttl used when a lock request does not provide one
+func WithDefaultLockTTL(ttl time.Duration) ServiceOption {
+	return func(s *service) {
+		s.defaultLockTTL = ttl
+	}
+}
+
+// WithReleaseBatchSize sets how many items with expired
+// locks are released in each transaction
+func WithReleaseBatchSize(size int) ServiceOption {
+	return func(s *service) {
+		if size > 0 {
+			s.releaseBatch = size
+		}
 	}
 }
 
@@ -29,6 +61,8 @@ func (s *service) CreateItems(ctx context.Context, userID, correlationID string,
 		"correlation_id": correlationID,
 	}
 
+	ctx = core.WithCorrelationID(ctx, correlationID)
+
 	items := make([]*Item, len(req.Items))
 
 	for i, it := range req.Items {
@@ -38,7 +72,7 @@ func (s *service) CreateItems(ctx context.Context, userID, correlationID string,
 			it.Name,
 			it.Description,
 			it.Quantity,
-			ItemPendingUpdateDispatch,
+			ItemAvailable,
 		)
 
 		if err != nil {
@@ -67,6 +101,8 @@ func (s *service) UpdateItems(ctx context.Context, userID, correlationID string,
 		"correlation_id": correlationID,
 	}
 
+	ctx = core.WithCorrelationID(ctx, correlationID)
+
 	itemsToUpdate := make(map[string]*UpdateItemModel, len(req.Items))
 	ids := make([]string, len(req.Items))
 
@@ -87,6 +123,13 @@ func (s *service) UpdateItems(ctx context.Context, userID, correlationID string,
 	for _, item := range items {
 		itemToUpdate := itemsToUpdate[item.ID]
 
+		if itemToUpdate.Version != nil {
+			if err := item.CheckVersion(*itemToUpdate.Version); err != nil {
+				logrus.WithError(err).WithFields(fields).Error("item has been changed since expected version")
+				return err
+			}
+		}
+
 		err := item.Update(itemToUpdate.Name, itemToUpdate.Description, itemToUpdate.Quantity)
 
 		if err != nil {
@@ -114,6 +157,11 @@ func (s *service) LockItems(ctx context.Context, req *LockItemsRequest) error {
 		"wanted_items_owner_id": req.WantedItemsOwnerID,
 	}
 
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = s.defaultLockTTL
+	}
+
 	wantedIDs := make([]string, len(req.WantedItems))
 	for i, item := range req.WantedItems {
 		wantedIDs[i] = item.ID
@@ -140,28 +188,74 @@ func (s *service) LockItems(ctx context.Context, req *LockItemsRequest) error {
 		itemsToLock[item.ID] = item
 	}
 
-	items, err := s.repository.Get(ctx, &req.OwnerID, ids)
+	err = s.repository.WithTransaction(ctx, func(ctx context.Context) error {
+		// offered items rows stay locked until the transaction ends,
+		// so concurrent trades cannot lock the same quantity twice
+		items, err := s.repository.GetForUpdate(ctx, &req.OwnerID, ids)
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while getting offered items")
+			return err
+		}
+
+		for _, item := range items {
+			itemToUpdate := itemsToLock[item.ID]
+
+			if err := item.Lock(req.LockedBy, itemToUpdate.Quantity, ttl); err != nil {
+				logrus.WithError(err).WithFields(fields).Error("error item lock failed")
+				return err
+			}
+		}
+
+		return s.repository.UpdateBulk(ctx, items)
+	})
 
 	if err != nil {
-		logrus.WithError(err).WithFields(fields).Error("error while getting offered items")
+		logrus.WithError(err).WithFields(fields).Error("error while locking items")
 		return err
 	}
 
-	for _, item := range items {
-		itemToUpdate := itemsToLock[item.ID]
+	logrus.WithFields(fields).Info("all items updated successfully")
+
+	return nil
+}
+
+// UnlockItems ...
+func (s *service) UnlockItems(ctx context.Context, req *UnlockItemsRequest) error {
+
+	fields := logrus.Fields{
+		"locked_by": req.LockedBy,
+		"owner_id":  req.OwnerID,
+		"ids":       req.IDs,
+	}
 
-		if err := item.Lock(req.LockedBy, itemToUpdate.Quantity); err != nil {
-			logrus.WithError(err).WithFields(fields).Error("error item lock failed")
+	err := s.repository.WithTransaction(ctx, func(ctx context.Context) error {
+		items, err := s.repository.GetForUpdate(ctx, &req.OwnerID, req.IDs)
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while getting locked items")
 			return err
 		}
-	}
 
-	if err := s.repository.UpdateBulk(ctx, items); err != nil {
-		logrus.WithError(err).WithFields(fields).Error("error while updating items")
+		if len(items) != len(req.IDs) {
+			logrus.WithError(core.ErrNotFound).WithFields(fields).Error("tried to unlock invalid items")
+			return core.ErrNotFound
+		}
+
+		for _, item := range items {
+			if err := item.Unlock(req.LockedBy); err != nil {
+				logrus.WithError(err).WithFields(fields).Error("error item unlock failed")
+				return err
+			}
+		}
+
+		return s.repository.UpdateBulk(ctx, items)
+	})
+
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while unlocking items")
 		return err
 	}
 
-	logrus.WithFields(fields).Info("all items updated successfully")
+	logrus.WithFields(fields).Info("all items unlocked successfully")
 
 	return nil
 }
@@ -177,19 +271,29 @@ func (s *service) TradeItems(ctx context.Context, req *TradeItemsRequest) error
 		"wanted_items_owner_id": req.WantedItemsOwnerID,
 	}
 
-	// Getting all offered items
-	offeredIDs := make([]string, len(req.OfferedItems))
-	for i, item := range req.OfferedItems {
-		offeredIDs[i] = item.ID
-	}
+	err := s.repository.WithTransaction(ctx, func(ctx context.Context) error {
+		return s.tradeItems(ctx, req, fields)
+	})
 
-	offeredItems, err := s.repository.Get(ctx, &req.OwnerID, offeredIDs)
 	if err != nil {
-		logrus.WithError(err).WithFields(fields).Error("error while getting offered items")
+		logrus.WithError(err).WithFields(fields).Error("error while settling trade, rolled back")
 		return err
 	}
 
-	// Getting all wanted items
+	logrus.WithFields(fields).Info("trade settled successfully")
+
+	return nil
+}
+
+// tradeItems must run within a transaction, every item involved
+// in the trade stays locked until it ends
+func (s *service) tradeItems(ctx context.Context, req *TradeItemsRequest, fields logrus.Fields) error {
+
+	offeredIDs := make([]string, len(req.OfferedItems))
+	for i, item := range req.OfferedItems {
+		offeredIDs[i] = item.ID
+	}
+
 	wantedIDs := make([]string, len(req.WantedItems))
 	wantedQuantities := make(map[string]int64, len(req.WantedItems))
 	for i, item := range req.WantedItems {
@@ -197,37 +301,31 @@ func (s *service) TradeItems(ctx context.Context, req *TradeItemsRequest) error
 		wantedQuantities[item.ID] = item.Quantity
 	}
 
-	wantedItems, err := s.repository.Get(ctx, &req.WantedItemsOwnerID, wantedIDs)
+	// offered and wanted items are locked by a single query in id order,
+	// so trades sharing items wait on each other instead of deadlocking
+	items, err := s.repository.GetForUpdate(ctx, nil, append(offeredIDs, wantedIDs...))
 	if err != nil {
-		logrus.WithError(err).WithFields(fields).Error("error while getting wanted items")
+		logrus.WithError(err).WithFields(fields).Error("error while getting traded items")
 		return err
 	}
 
+	offeredItems := filterOwnedItems(items, req.OwnerID, offeredIDs)
+	wantedItems := filterOwnedItems(items, req.WantedItemsOwnerID, wantedIDs)
+
 	var itemsToAdd []*Item
 	var itemsToUpdate []*Item
-	var itemsToDelete []string
+	var itemsToDelete []*Item
 
 	for _, item := range offeredItems {
-		var offeredQuantity ItemQuantity
-		newLocks := make([]*ItemLock, len(item.Locks)-1)
-		for _, lock := range item.Locks {
-			if lock.LockedBy == req.TradeID {
-				offeredQuantity = lock.Quantity
-			} else {
-				newLocks = append(newLocks, lock)
-			}
+		// offered items are traded by the quantity locked for the trade
+		var offeredQuantity int64
+		if lock := item.GetLock(req.TradeID); lock != nil {
+			offeredQuantity = int64(lock.Quantity)
 		}
 
-		item.TotalQuantity = item.TotalQuantity - offeredQuantity
-		item.Locks = newLocks
-
-		newItem, err := NewItem(
-			uuid.NewString(), req.WantedItemsOwnerID, string(item.Name),
-			(*string)(item.Description), int64(offeredQuantity), ItemPendingUpdateDispatch,
-		)
-
+		newItem, err := item.Transfer(req.TradeID, uuid.NewString(), req.WantedItemsOwnerID, offeredQuantity)
 		if err != nil {
-			logrus.WithError(err).WithFields(fields).Error("error while creating new item to add")
+			logrus.WithError(err).WithFields(fields).Error("error while transfering offered item")
 			return err
 		}
 
@@ -236,35 +334,15 @@ func (s *service) TradeItems(ctx context.Context, req *TradeItemsRequest) error
 		if item.TotalQuantity > 0 {
 			itemsToUpdate = append(itemsToUpdate, item)
 		} else {
-			itemsToDelete = append(itemsToDelete, item.ID)
+			item.Delete()
+			itemsToDelete = append(itemsToDelete, item)
 		}
 	}
 
 	for _, item := range wantedItems {
-		wantedQuantity := ItemQuantity(wantedQuantities[item.ID])
-
-		if item.TotalQuantity < wantedQuantity {
-			logrus.
-				WithError(core.ErrValidationFailed).
-				WithFields(fields).
-				Error("wanted quantity bigger than total quantity")
-
-			return core.ErrValidationFailed
-		}
-
-		item.TotalQuantity = item.TotalQuantity - wantedQuantity
-
-		newItem, err := NewItem(
-			uuid.NewString(),
-			req.OwnerID,
-			string(item.Name),
-			(*string)(item.Description),
-			int64(wantedQuantity),
-			ItemPendingUpdateDispatch,
-		)
-
+		newItem, err := item.Transfer(req.TradeID, uuid.NewString(), req.OwnerID, wantedQuantities[item.ID])
 		if err != nil {
-			logrus.WithError(err).WithFields(fields).Error("error while creating new item to add")
+			logrus.WithError(err).WithFields(fields).Error("error while transfering wanted item")
 			return err
 		}
 
@@ -273,32 +351,26 @@ func (s *service) TradeItems(ctx context.Context, req *TradeItemsRequest) error
 		if item.TotalQuantity > 0 {
 			itemsToUpdate = append(itemsToUpdate, item)
 		} else {
-			itemsToDelete = append(itemsToDelete, item.ID)
+			item.Delete()
+			itemsToDelete = append(itemsToDelete, item)
 		}
 	}
 
-	//TODO: make this operationg a single transaction
 	if err := s.repository.UpdateBulk(ctx, itemsToUpdate); err != nil {
 		logrus.WithError(err).WithFields(fields).Error("error while updating items")
 		return err
 	}
 
-	logrus.WithFields(fields).Error("items updated")
-
 	if err := s.repository.InsertBulk(ctx, itemsToAdd); err != nil {
 		logrus.WithError(err).WithFields(fields).Error("error while inserting items")
 		return err
 	}
 
-	logrus.WithFields(fields).Error("items inserted")
-
 	if err := s.repository.DeleteBulk(ctx, itemsToDelete); err != nil {
 		logrus.WithError(err).WithFields(fields).Error("error while deleting items")
 		return err
 	}
 
-	logrus.WithFields(fields).Error("items deleted")
-
 	return nil
 }
 
@@ -311,18 +383,19 @@ func (s *service) DeleteItems(ctx context.Context, userID, correlationID string,
 		"correlation_id": correlationID,
 	}
 
+	ctx = core.WithCorrelationID(ctx, correlationID)
+
 	items, err := s.repository.Get(ctx, &userID, req.IDs)
 	if err != nil {
 		logrus.WithError(err).WithFields(fields).Error("error while getting items")
 		return err
 	}
 
-	ids := make([]string, len(items))
-	for i, item := range items {
-		ids[i] = item.ID
+	for _, item := range items {
+		item.Delete()
 	}
 
-	if err := s.repository.DeleteBulk(ctx, ids); err != nil {
+	if err := s.repository.DeleteBulk(ctx, items); err != nil {
 		logrus.WithError(err).WithFields(fields).Error("error while deleting items")
 		return err
 	}
@@ -331,3 +404,202 @@ func (s *service) DeleteItems(ctx context.Context, userID, correlationID string,
 
 	return nil
 }
+
+// ReleaseExpiredLocks releases the expired locks in batches, each one in its
+// own transaction, until a batch comes back smaller than the batch size
+func (s *service) ReleaseExpiredLocks(ctx context.Context) error {
+
+	now := time.Now()
+	fields := logrus.Fields{"until": now, "batch_size": s.releaseBatch}
+
+	var released int
+
+	for {
+		read, batchReleased, err := s.releaseExpiredLocks(ctx, now)
+		released = released + batchReleased
+		fields["items"] = released
+
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while releasing expired locks")
+			return err
+		}
+
+		if read < s.releaseBatch {
+			break
+		}
+	}
+
+	logrus.WithFields(fields).Info("released expired locks successfully")
+
+	return nil
+}
+
+// releaseExpiredLocks releases the expired locks of a single batch of items,
+// returning how many items were read and how many of them were released
+func (s *service) releaseExpiredLocks(ctx context.Context, now time.Time) (int, int, error) {
+	var read, released int
+
+	err := s.repository.WithTransaction(ctx, func(ctx context.Context) error {
+		items, err := s.repository.GetWithExpiredLocks(ctx, now, s.releaseBatch)
+		if err != nil {
+			return err
+		}
+
+		var itemsToUpdate []*Item
+		for _, item := range items {
+			if item.ReleaseExpiredLocks(now) {
+				itemsToUpdate = append(itemsToUpdate, item)
+			}
+		}
+
+		read = len(items)
+
+		if len(itemsToUpdate) < 1 {
+			return nil
+		}
+
+		if err := s.repository.UpdateBulk(ctx, itemsToUpdate); err != nil {
+			return err
+		}
+
+		released = len(itemsToUpdate)
+
+		return nil
+	})
+
+	if err != nil {
+		return read, 0, err
+	}
+
+	return read, released, nil
+}
+
+// ReleaseTradeLocks releases every lock held by a trade which will not be
+// settled. Trades without locks are ignored, so a trade can be released
+// any number of times
+func (s *service) ReleaseTradeLocks(ctx context.Context, req *ReleaseTradeLocksRequest) error {
+
+	fields := logrus.Fields{
+		"trade_id": req.TradeID,
+		"reason":   req.Reason,
+	}
+
+	if req.TradeID == "" {
+		logrus.WithError(core.ErrValidationFailed).WithFields(fields).Error("tried to release locks without trade id")
+		return core.ErrValidationFailed
+	}
+
+	var released int
+
+	err := s.repository.WithTransaction(ctx, func(ctx context.Context) error {
+		items, err := s.repository.GetLockedByForUpdate(ctx, req.TradeID)
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while getting items locked by trade")
+			return err
+		}
+
+		var itemsToUpdate []*Item
+		for _, item := range items {
+			if item.ReleaseTradeLock(req.TradeID, req.Reason) {
+				itemsToUpdate = append(itemsToUpdate, item)
+			}
+		}
+
+		released = len(itemsToUpdate)
+
+		if released < 1 {
+			return nil
+		}
+
+		return s.repository.UpdateBulk(ctx, itemsToUpdate)
+	})
+
+	fields["items"] = released
+
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while releasing trade locks")
+		return err
+	}
+
+	logrus.WithFields(fields).Info("released trade locks successfully")
+
+	return nil
+}
+
+// DeleteOwnerItems removes every item of a deleted owner, as told by the
+// policy, releasing the locks held on them by the trades of the owner first.
+// Deleting the items of an owner without items is a no-op
+func (s *service) DeleteOwnerItems(ctx context.Context, req *DeleteOwnerItemsRequest) error {
+
+	fields := logrus.Fields{
+		"owner_id": req.OwnerID,
+		"policy":   req.Policy,
+	}
+
+	if req.OwnerID == "" || req.OwnerID == AnonymizedOwnerID {
+		logrus.WithError(core.ErrValidationFailed).WithFields(fields).Error("tried to delete items without owner id")
+		return core.ErrValidationFailed
+	}
+
+	var deleted int
+
+	err := s.repository.WithTransaction(ctx, func(ctx context.Context) error {
+		items, err := s.repository.GetByOwnerForUpdate(ctx, req.OwnerID)
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("error while getting owner items")
+			return err
+		}
+
+		deleted = len(items)
+
+		if deleted < 1 {
+			return nil
+		}
+
+		for _, item := range items {
+			item.ReleaseLocks(ItemUnlockOwnerDeleted)
+		}
+
+		if req.Policy == DeletionPolicyAnonymize {
+			for _, item := range items {
+				item.Anonymize()
+			}
+
+			return s.repository.UpdateBulk(ctx, items)
+		}
+
+		for _, item := range items {
+			item.Delete()
+		}
+
+		return s.repository.DeleteBulk(ctx, items)
+	})
+
+	fields["items"] = deleted
+
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("error while deleting owner items")
+		return err
+	}
+
+	logrus.WithFields(fields).Info("deleted owner items successfully")
+
+	return nil
+}
+
+// filterOwnedItems returns the items of ownerID among ids
+func filterOwnedItems(items []*Item, ownerID string, ids []string) []*Item {
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	var owned []*Item
+	for _, item := range items {
+		if item.OwnerID == ownerID && selected[item.ID] {
+			owned = append(owned, item)
+		}
+	}
+
+	return owned
+}
@@ -2,7 +2,9 @@ package inventory_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/bxcodec/faker/v3"
 	"github.com/d-leme/tradew-inventory-write/pkg/core"
@@ -183,6 +185,41 @@ func (s *serviceTestSuite) TestUpdateItemsInvalidItem() {
 	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 0)
 }
 
+func (s *serviceTestSuite) TestUpdateItemsVersionConflict() {
+
+	correlationID := uuid.NewString()
+	userID := uuid.NewString()
+
+	items := createItems(2, userID)
+	ids := make([]string, len(items))
+	itemModels := make([]*inventory.UpdateItemModel, len(items))
+
+	for i, item := range items {
+		version := item.Version
+		ids[i] = item.ID
+		itemModels[i] = &inventory.UpdateItemModel{
+			ID:       item.ID,
+			Name:     string(item.Name),
+			Quantity: 10,
+			Version:  &version,
+		}
+	}
+
+	staleVersion := items[1].Version - 1
+	itemModels[1].Version = &staleVersion
+
+	s.repository.On("Get", ids).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.UpdateItemsRequest{Items: itemModels}
+
+	err := s.service.UpdateItems(s.ctx, userID, correlationID, req)
+
+	s.assert.ErrorIs(err, core.ErrVersionConflict)
+	s.repository.AssertNumberOfCalls(s.T(), "Get", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 0)
+}
+
 func (s *serviceTestSuite) TestLockItemsInvalidWantedItem() {
 
 	lockedBy := uuid.NewString()
@@ -223,7 +260,7 @@ func (s *serviceTestSuite) TestLockItemsInvalidWantedItem() {
 	wantedIDs = append(wantedIDs, invalidItem.ID)
 	wantedItemModels = append(wantedItemModels, invalidItem)
 
-	s.repository.On("Get", offeredIDs).Return(offeredItems, nil)
+	s.repository.On("GetForUpdate", offeredIDs).Return(offeredItems, nil)
 	s.repository.On("Get", wantedIDs).Return(wantedItems, nil)
 	s.repository.On("UpdateBulk", anyItems).Return(nil)
 
@@ -239,6 +276,7 @@ func (s *serviceTestSuite) TestLockItemsInvalidWantedItem() {
 
 	s.assert.ErrorIs(core.ErrInvalidWantedItems, err)
 	s.repository.AssertNumberOfCalls(s.T(), "Get", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "GetForUpdate", 0)
 	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 0)
 }
 
@@ -274,7 +312,7 @@ func (s *serviceTestSuite) TestLockItems() {
 		}
 	}
 
-	s.repository.On("Get", offeredIDs).Return(offeredItems, nil)
+	s.repository.On("GetForUpdate", offeredIDs).Return(offeredItems, nil)
 	s.repository.On("Get", wantedIDs).Return(wantedItems, nil)
 	s.repository.On("UpdateBulk", anyItems).Return(nil)
 
@@ -289,10 +327,354 @@ func (s *serviceTestSuite) TestLockItems() {
 	err := s.service.LockItems(s.ctx, req)
 
 	s.assert.NoError(err)
-	s.repository.AssertNumberOfCalls(s.T(), "Get", 2)
+	s.repository.AssertNumberOfCalls(s.T(), "Get", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "GetForUpdate", 1)
 	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
 }
 
+func (s *serviceTestSuite) TestUnlockItems() {
+
+	lockedBy := uuid.NewString()
+	ownerID := uuid.NewString()
+
+	items := createItems(2, ownerID)
+	ids := make([]string, len(items))
+
+	for i, item := range items {
+		ids[i] = item.ID
+		s.assert.NoError(item.Lock(lockedBy, 3, 0))
+	}
+
+	s.repository.On("GetForUpdate", ids).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.UnlockItemsRequest{
+		LockedBy: lockedBy,
+		OwnerID:  ownerID,
+		IDs:      ids,
+	}
+
+	err := s.service.UnlockItems(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetForUpdate", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+
+	for _, item := range items {
+		s.assert.Empty(item.Locks)
+	}
+}
+
+func (s *serviceTestSuite) TestUnlockItemsLockNotFound() {
+
+	ownerID := uuid.NewString()
+
+	items := createItems(2, ownerID)
+	ids := make([]string, len(items))
+
+	for i, item := range items {
+		ids[i] = item.ID
+		s.assert.NoError(item.Lock(uuid.NewString(), 3, 0))
+	}
+
+	s.repository.On("GetForUpdate", ids).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.UnlockItemsRequest{
+		LockedBy: uuid.NewString(),
+		OwnerID:  ownerID,
+		IDs:      ids,
+	}
+
+	err := s.service.UnlockItems(s.ctx, req)
+
+	s.assert.ErrorIs(err, core.ErrNotFound)
+	s.repository.AssertNumberOfCalls(s.T(), "GetForUpdate", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 0)
+}
+
+func (s *serviceTestSuite) TestReleaseExpiredLocks() {
+
+	items := createItems(2, uuid.NewString())
+
+	for _, item := range items {
+		s.assert.NoError(item.Lock(uuid.NewString(), 1, time.Nanosecond))
+		s.assert.NoError(item.Lock(uuid.NewString(), 1, time.Hour))
+	}
+
+	s.repository.On("GetWithExpiredLocks", 100).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	err := s.service.ReleaseExpiredLocks(s.ctx)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetWithExpiredLocks", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+
+	for _, item := range items {
+		s.assert.Len(item.Locks, 1)
+	}
+}
+
+func (s *serviceTestSuite) TestReleaseExpiredLocksInBatches() {
+
+	s.service = inventory.NewService(s.repository, inventory.WithReleaseBatchSize(2))
+
+	items := createItems(5, uuid.NewString())
+
+	for _, item := range items {
+		s.assert.NoError(item.Lock(uuid.NewString(), 1, time.Nanosecond))
+	}
+
+	// batches are read until one is not full
+	s.repository.On("GetWithExpiredLocks", 2).Return(items[:2], nil).Once()
+	s.repository.On("GetWithExpiredLocks", 2).Return(items[2:4], nil).Once()
+	s.repository.On("GetWithExpiredLocks", 2).Return(items[4:], nil).Once()
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	err := s.service.ReleaseExpiredLocks(s.ctx)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetWithExpiredLocks", 3)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 3)
+
+	for _, item := range items {
+		s.assert.Empty(item.Locks)
+	}
+}
+
+func (s *serviceTestSuite) TestReleaseExpiredLocksBatchFailed() {
+
+	s.service = inventory.NewService(s.repository, inventory.WithReleaseBatchSize(2))
+
+	items := createItems(4, uuid.NewString())
+
+	for _, item := range items {
+		s.assert.NoError(item.Lock(uuid.NewString(), 1, time.Nanosecond))
+	}
+
+	s.repository.On("GetWithExpiredLocks", 2).Return(items[:2], nil).Once()
+	s.repository.On("GetWithExpiredLocks", 2).Return(nil, errors.New("get failed")).Once()
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	err := s.service.ReleaseExpiredLocks(s.ctx)
+
+	s.assert.Error(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetWithExpiredLocks", 2)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+}
+
+func (s *serviceTestSuite) TestReleaseTradeLocks() {
+
+	tradeID := uuid.NewString()
+	items := createItems(2, uuid.NewString())
+
+	for _, item := range items {
+		s.assert.NoError(item.Lock(tradeID, 1, 0))
+		s.assert.NoError(item.Lock(uuid.NewString(), 1, 0))
+	}
+
+	s.repository.On("GetLockedByForUpdate", tradeID).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.ReleaseTradeLocksRequest{
+		TradeID: tradeID,
+		Reason:  inventory.ItemUnlockCancelled,
+	}
+
+	err := s.service.ReleaseTradeLocks(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetLockedByForUpdate", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+
+	for _, item := range items {
+		s.assert.Len(item.Locks, 1)
+		s.assert.NotEqual(tradeID, item.Locks[0].LockedBy)
+	}
+}
+
+func (s *serviceTestSuite) TestReleaseTradeLocksAlreadyReleased() {
+
+	tradeID := uuid.NewString()
+
+	s.repository.On("GetLockedByForUpdate", tradeID).Return([]*inventory.Item{}, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.ReleaseTradeLocksRequest{
+		TradeID: tradeID,
+		Reason:  inventory.ItemUnlockRejected,
+	}
+
+	err := s.service.ReleaseTradeLocks(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetLockedByForUpdate", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 0)
+}
+
+func (s *serviceTestSuite) TestReleaseTradeLocksWithoutTradeID() {
+
+	err := s.service.ReleaseTradeLocks(s.ctx, &inventory.ReleaseTradeLocksRequest{
+		Reason: inventory.ItemUnlockCancelled,
+	})
+
+	s.assert.ErrorIs(err, core.ErrValidationFailed)
+	s.repository.AssertNumberOfCalls(s.T(), "GetLockedByForUpdate", 0)
+}
+
+func (s *serviceTestSuite) TestTradeCancelledHandler() {
+
+	tradeID := uuid.NewString()
+	items := createItems(1, uuid.NewString())
+
+	s.assert.NoError(items[0].Lock(tradeID, 1, 0))
+
+	s.repository.On("GetLockedByForUpdate", tradeID).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	handler := inventory.NewTradeCancelledHandler(s.service, inventory.ItemUnlockCancelled)
+
+	err := handler(&core.Message{
+		ID:   uuid.NewString(),
+		Body: &inventory.TradeCancelledEvent{TradeID: tradeID},
+	})
+
+	s.assert.NoError(err)
+	s.assert.Empty(items[0].Locks)
+}
+
+func (s *serviceTestSuite) TestDeleteOwnerItems() {
+
+	ownerID := uuid.NewString()
+	items := createItems(2, ownerID)
+
+	s.assert.NoError(items[0].Lock(uuid.NewString(), 1, 0))
+
+	s.repository.On("GetByOwnerForUpdate", ownerID).Return(items, nil)
+	s.repository.On("DeleteBulk", anyItems).Return(nil)
+
+	req := &inventory.DeleteOwnerItemsRequest{
+		OwnerID: ownerID,
+		Policy:  inventory.DeletionPolicyDelete,
+	}
+
+	err := s.service.DeleteOwnerItems(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "DeleteBulk", 1)
+	s.repository.AssertNotCalled(s.T(), "UpdateBulk", anyItems)
+
+	for _, item := range items {
+		s.assert.Empty(item.Locks)
+		s.assert.Equal(ownerID, item.OwnerID)
+	}
+}
+
+func (s *serviceTestSuite) TestDeleteOwnerItemsAnonymize() {
+
+	ownerID := uuid.NewString()
+	items := createItems(2, ownerID)
+
+	s.assert.NoError(items[0].Lock(uuid.NewString(), 1, 0))
+
+	s.repository.On("GetByOwnerForUpdate", ownerID).Return(items, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.DeleteOwnerItemsRequest{
+		OwnerID: ownerID,
+		Policy:  inventory.DeletionPolicyAnonymize,
+	}
+
+	err := s.service.DeleteOwnerItems(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+	s.repository.AssertNotCalled(s.T(), "DeleteBulk", anyItems)
+
+	for _, item := range items {
+		s.assert.Empty(item.Locks)
+		s.assert.Equal(inventory.AnonymizedOwnerID, item.OwnerID)
+		s.assert.Equal(inventory.ItemAnonymized, item.Status)
+		s.assert.Equal(inventory.AnonymizedItemDescription, *item.Description)
+	}
+}
+
+func (s *serviceTestSuite) TestDeleteOwnerItemsWithoutItems() {
+
+	ownerID := uuid.NewString()
+
+	s.repository.On("GetByOwnerForUpdate", ownerID).Return([]*inventory.Item{}, nil)
+
+	req := &inventory.DeleteOwnerItemsRequest{
+		OwnerID: ownerID,
+		Policy:  inventory.DeletionPolicyDelete,
+	}
+
+	err := s.service.DeleteOwnerItems(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetByOwnerForUpdate", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "DeleteBulk", 0)
+}
+
+func (s *serviceTestSuite) TestDeleteOwnerItemsWithoutOwnerID() {
+
+	err := s.service.DeleteOwnerItems(s.ctx, &inventory.DeleteOwnerItemsRequest{
+		Policy: inventory.DeletionPolicyDelete,
+	})
+
+	s.assert.ErrorIs(err, core.ErrValidationFailed)
+	s.repository.AssertNumberOfCalls(s.T(), "GetByOwnerForUpdate", 0)
+}
+
+func (s *serviceTestSuite) TestUserDeletedHandler() {
+
+	ownerID := uuid.NewString()
+	items := createItems(1, ownerID)
+
+	s.repository.On("GetByOwnerForUpdate", ownerID).Return(items, nil)
+	s.repository.On("DeleteBulk", anyItems).Return(errors.New("delete failed"))
+
+	handler := inventory.NewUserDeletedHandler(s.service, inventory.DeletionPolicyDelete)
+
+	err := handler(&core.Message{
+		ID:   uuid.NewString(),
+		Body: &inventory.UserDeletedEvent{UserID: ownerID},
+	})
+
+	s.assert.Error(err)
+}
+
+func (s *serviceTestSuite) TestLockItemsDefaultTTL() {
+
+	s.service = inventory.NewService(s.repository, inventory.WithDefaultLockTTL(time.Hour))
+
+	ownerID := uuid.NewString()
+	wantedItemsOwnerID := uuid.NewString()
+
+	offeredItems := createItems(1, ownerID)
+	wantedItems := createItems(1, wantedItemsOwnerID)
+
+	s.repository.On("GetForUpdate", []string{offeredItems[0].ID}).Return(offeredItems, nil)
+	s.repository.On("Get", []string{wantedItems[0].ID}).Return(wantedItems, nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+
+	req := &inventory.LockItemsRequest{
+		LockedBy:           uuid.NewString(),
+		OwnerID:            ownerID,
+		WantedItemsOwnerID: wantedItemsOwnerID,
+		OfferedItems:       []*inventory.LockItemModel{{ID: offeredItems[0].ID, Quantity: 1}},
+		WantedItems:        []*inventory.LockItemModel{{ID: wantedItems[0].ID, Quantity: 1}},
+	}
+
+	err := s.service.LockItems(s.ctx, req)
+
+	s.assert.NoError(err)
+	s.assert.NotNil(offeredItems[0].Locks[0].ExpiresAt)
+	s.assert.WithinDuration(time.Now().Add(time.Hour), *offeredItems[0].Locks[0].ExpiresAt, time.Minute)
+}
+
 func (s *serviceTestSuite) TestTradeItems() {
 
 	tradeID := uuid.NewString()
@@ -367,8 +749,7 @@ func (s *serviceTestSuite) TestTradeItems() {
 		}
 	}
 
-	s.repository.On("Get", offeredItemIDs).Return(offeredItems, nil)
-	s.repository.On("Get", wantedItemIDs).Return(wantedItems, nil)
+	s.repository.On("GetForUpdate", append(offeredItemIDs, wantedItemIDs...)).Return(append(offeredItems, wantedItems...), nil)
 	s.repository.On("UpdateBulk", testifyMock.MatchedBy(func(items []*inventory.Item) bool {
 		var (
 			countOffered, countWanted int
@@ -402,8 +783,8 @@ func (s *serviceTestSuite) TestTradeItems() {
 		return countOffered == 2 && countWanted == 2
 	})).Return(nil)
 
-	s.repository.On("DeleteBulk", testifyMock.MatchedBy(func(ids []string) bool {
-		return len(ids) == len(wantedItems)
+	s.repository.On("DeleteBulk", testifyMock.MatchedBy(func(items []*inventory.Item) bool {
+		return len(items) == len(wantedItems)
 	})).Return(nil)
 
 	req := &inventory.TradeItemsRequest{
@@ -417,12 +798,81 @@ func (s *serviceTestSuite) TestTradeItems() {
 	err := s.service.TradeItems(s.ctx, req)
 
 	s.assert.NoError(err)
-	s.repository.AssertNumberOfCalls(s.T(), "Get", 2)
+	s.repository.AssertNumberOfCalls(s.T(), "GetForUpdate", 1)
 	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
 	s.repository.AssertNumberOfCalls(s.T(), "InsertBulk", 1)
 	s.repository.AssertNumberOfCalls(s.T(), "DeleteBulk", 1)
 }
 
+func (s *serviceTestSuite) TestTradeItemsInsertFailed() {
+
+	tradeID := uuid.NewString()
+	ownerID := uuid.NewString()
+	wantedItemsOwnerID := uuid.NewString()
+
+	offeredItems := createItems(1, ownerID)
+	wantedItems := createItems(1, wantedItemsOwnerID)
+
+	s.assert.NoError(offeredItems[0].Lock(tradeID, 1, 0))
+
+	s.repository.On("GetForUpdate", []string{offeredItems[0].ID, wantedItems[0].ID}).Return(append(offeredItems, wantedItems...), nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+	s.repository.On("InsertBulk", anyItems).Return(errors.New("insert failed"))
+	s.repository.On("DeleteBulk", anyItems).Return(nil)
+
+	req := &inventory.TradeItemsRequest{
+		TradeID:            tradeID,
+		OwnerID:            ownerID,
+		WantedItemsOwnerID: wantedItemsOwnerID,
+		OfferedItems:       []*inventory.TradeItemModel{{ID: offeredItems[0].ID, Quantity: 1}},
+		WantedItems:        []*inventory.TradeItemModel{{ID: wantedItems[0].ID, Quantity: 1}},
+	}
+
+	err := s.service.TradeItems(s.ctx, req)
+
+	s.assert.Error(err)
+	s.repository.AssertNumberOfCalls(s.T(), "UpdateBulk", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "InsertBulk", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "DeleteBulk", 0)
+}
+
+func (s *serviceTestSuite) TestTradeItemsOfOtherOwners() {
+
+	tradeID := uuid.NewString()
+	ownerID := uuid.NewString()
+	wantedItemsOwnerID := uuid.NewString()
+
+	offeredItems := createItems(1, ownerID)
+	othersItems := createItems(1, uuid.NewString())
+
+	s.assert.NoError(offeredItems[0].Lock(tradeID, 1, 0))
+
+	ids := []string{offeredItems[0].ID, othersItems[0].ID}
+
+	s.repository.On("GetForUpdate", ids).Return(append(offeredItems, othersItems...), nil)
+	s.repository.On("UpdateBulk", anyItems).Return(nil)
+	s.repository.On("InsertBulk", testifyMock.MatchedBy(func(items []*inventory.Item) bool {
+		return len(items) == 1 && items[0].OwnerID == wantedItemsOwnerID
+	})).Return(nil)
+	s.repository.On("DeleteBulk", anyItems).Return(nil)
+
+	req := &inventory.TradeItemsRequest{
+		TradeID:            tradeID,
+		OwnerID:            ownerID,
+		WantedItemsOwnerID: wantedItemsOwnerID,
+		OfferedItems:       []*inventory.TradeItemModel{{ID: offeredItems[0].ID, Quantity: 1}},
+		WantedItems:        []*inventory.TradeItemModel{{ID: othersItems[0].ID, Quantity: 1}},
+	}
+
+	err := s.service.TradeItems(s.ctx, req)
+
+	// items not owned by the wanted items owner are left untouched
+	s.assert.NoError(err)
+	s.repository.AssertNumberOfCalls(s.T(), "GetForUpdate", 1)
+	s.repository.AssertNumberOfCalls(s.T(), "InsertBulk", 1)
+	s.assert.Len(othersItems[0].Events(), 1)
+}
+
 func createItemModel() *inventory.CreateItemModel {
 	faker.SetRandomStringLength(15)
 